@@ -1,12 +1,19 @@
 package fast
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,18 +21,78 @@ import (
 )
 
 type Config struct {
-	IdleTimeout time.Duration // seconds
+	// IdleTimeout is how long a keep-alive connection waits for the next request, 120s by default.
+	IdleTimeout time.Duration
+	// ReadHeaderTimeout is how long a client has to send the request head once it starts, so the
+	// clients trickling the headers are cut off. ReadTimeout is used when it's zero, IdleTimeout when both are.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is how long a client has to send the whole request, body included. No limit by default.
+	ReadTimeout time.Duration
+	// WriteTimeout is how long the handlers have to send the response once the head is read,
+	// it includes the streamed responses. No limit by default.
+	WriteTimeout time.Duration
+
+	BodyLimit int64 // max size in bytes of a request body, 4MB by default
+
+	// DisableMethodNotAllowed answers 404 instead of 405 when the path only exists for other methods.
+	DisableMethodNotAllowed bool
+	// DisableAutoOptions stops answering OPTIONS with 204 and the "Allow" header for paths without an OPTIONS route.
+	DisableAutoOptions bool
+	// PreserveHeaderCase sends the response headers with the exact case used by the handlers,
+	// by default they are sent in the canonical form as "Content-Type".
+	PreserveHeaderCase bool
+
+	// MaxHeaderBytes is the max size of the request head, request line included, 1MB by default.
+	MaxHeaderBytes int
+	// MaxHeaderCount is the max number of header fields in a request, 100 by default.
+	// The trailers of a chunked body are limited by both as a head of their own.
+	MaxHeaderCount int
+	// MaxURILength is the max length of the request target, 8KB by default.
+	MaxURILength int
+
+	// ErrorHandler answers the requests whose handlers returned an error and the ones that
+	// couldn't be read, as a malformed request or one over the limits. DefaultErrorHandler by default.
+	ErrorHandler ErrorHandler
+
+	// MaxConns is the max number of connections served at once, the new ones
+	// are answered with 503 and closed. No limit by default.
+	MaxConns int
+	// MaxConnsPerIP is the max number of connections served at once for a client IP, the new ones
+	// are answered with 429 and closed. No limit by default.
+	MaxConnsPerIP int
+	// MaxRequestsPerConn is the max number of requests served by a keep-alive connection,
+	// the last one is answered with "Connection: close". No limit by default.
+	MaxRequestsPerConn int
+
+	// DisableHTTP2 serves only HTTP/1.x: h2 isn't offered by App.ListenTLS, and the h2c connections
+	// are refused with prior knowledge and answered with HTTP/1.1 with "Upgrade: h2c".
+	DisableHTTP2 bool
+	// MaxConcurrentStreams is the max number of requests an HTTP/2 connection sends at once,
+	// the ones over it are refused for the client to retry them. 250 by default.
+	MaxConcurrentStreams int
+
+	// TLSConfig is the base configuration of App.ListenTLS, as the min version or the cipher suites.
+	TLSConfig *tls.Config
+	// CertReloadInterval is how often the certificate files of App.ListenTLS are checked for
+	// changes, 10s by default. They are only reloaded on SIGHUP when it's negative.
+	CertReloadInterval time.Duration
 }
 
 type App struct {
 	config      Config
-	addr        string
+	lnMu        sync.Mutex
 	ln          net.Listener
 	middlewares []Handler
-	routes      map[string]map[string][]Handler // "method" -> "path"
-	quit        chan struct{}
+	routes      map[string]*node // "method" -> tree of paths
 	wg          sync.WaitGroup
 	activeConns atomic.Int64
+	connsMu     sync.Mutex
+	conns       map[*connection]struct{} // served connections, closed by the shutdown
+	connsPerIP  map[string]int           // only tracked with Config.MaxConnsPerIP
+	certs       certificates
+
+	shuttingDown atomic.Bool
+	stopped      chan struct{} // closed once the listener stops accepting connections
 }
 
 type Handler func(*Ctx) error
@@ -37,183 +104,743 @@ func New(c Config) *App {
 		c.IdleTimeout = time.Second * 120
 	}
 
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = c.ReadTimeout
+	}
+
+	if c.ReadHeaderTimeout == 0 {
+		c.ReadHeaderTimeout = c.IdleTimeout
+	}
+
+	if c.BodyLimit == 0 {
+		c.BodyLimit = 4 * 1024 * 1024
+	}
+
+	if c.MaxHeaderBytes == 0 {
+		c.MaxHeaderBytes = defaultMaxHeaderBytes
+	}
+
+	if c.MaxHeaderCount == 0 {
+		c.MaxHeaderCount = 100
+	}
+
+	if c.MaxURILength == 0 {
+		c.MaxURILength = 8 * 1024
+	}
+
+	if c.MaxConcurrentStreams == 0 {
+		c.MaxConcurrentStreams = defaultMaxConcurrentStreams
+	}
+
+	if c.ErrorHandler == nil {
+		c.ErrorHandler = DefaultErrorHandler
+	}
+
 	return &App{
-		config: c,
-		routes: make(map[string]map[string][]Handler),
-		quit:   make(chan struct{}),
+		config:     c,
+		routes:     make(map[string]*node),
+		conns:      make(map[*connection]struct{}),
+		connsPerIP: make(map[string]int),
+		stopped:    make(chan struct{}),
 	}
 }
 
-// Expected -> :8090
+// Listen serves the app on the TCP address, as ":8090" or ":0" for a random port
+// (see App.Addr). It blocks until the app is shut down, then it returns nil.
 func (app *App) Listen(addr string) error {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatal("failed to bind to the port 8097")
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 
-	app.addr = addr
-	app.ln = ln
-	app.acceptConnections()
+	return app.Listener(ln)
+}
+
+// Listener serves the app on an existing listener, as a Unix socket or a wrapped one.
+// It blocks until the app is shut down, then it returns nil, the listener is closed either way.
+func (app *App) Listener(ln net.Listener) error {
+	return app.serve(ln, nil)
+}
+
+var errListening = errors.New("the app is already listening or was shut down")
+
+// checkListening fails when the app can't listen anymore, before anything is set up for it.
+func (app *App) checkListening() error {
+	app.lnMu.Lock()
+	defer app.lnMu.Unlock()
+
+	if app.ln != nil || app.shuttingDown.Load() {
+		return errListening
+	}
 	return nil
 }
 
-func (app *App) acceptConnections() {
+// serve accepts the connections of the listener, started is called once the app is sure to
+// serve on it, so nothing used by a listener already serving is changed by a call failing.
+func (app *App) serve(ln net.Listener, started func()) error {
+	app.lnMu.Lock()
+	if app.ln != nil || app.shuttingDown.Load() {
+		app.lnMu.Unlock()
+		ln.Close()
+		return errListening
+	}
+	app.ln = ln
+	if started != nil {
+		started()
+	}
+	app.lnMu.Unlock()
+
+	return app.acceptConnections(ln)
+}
+
+// Addr returns the address the app is listening on, nil until it listens.
+func (app *App) Addr() net.Addr {
+	app.lnMu.Lock()
+	defer app.lnMu.Unlock()
+
+	if app.ln == nil {
+		return nil
+	}
+
+	return app.ln.Addr()
+}
+
+func (app *App) acceptConnections(ln net.Listener) error {
+	defer close(app.stopped)
+
+	var delay time.Duration
 	for {
-		select {
-		case <-app.quit:
-			// TODO: I could add some control to wait the current connections to end before this.
-			slog.Debug("received a quit command, shutting down new connections...")
-			return
-		default:
-			conn, err := app.ln.Accept()
-			if err != nil {
-				slog.Warn("failed to create a new connection, stoping the listener...", "error", err)
-				continue
+		conn, err := ln.Accept()
+		if err != nil {
+			if app.shuttingDown.Load() {
+				slog.Debug("the listener was closed, stopping to accept connections...")
+				return nil
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("the listener was closed: %w", err)
 			}
 
-			app.activeConns.Add(1)
+			// as running out of file descriptors, the error can go away after a while
+			delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+			slog.Warn("failed to accept a new connection, retrying...", "error", err, "delay", delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		if status := app.acquireConn(conn); status != 0 {
 			app.wg.Add(1)
-			go app.handleConnection(conn)
+			go app.rejectConnection(conn, status)
+			continue
 		}
+
+		app.wg.Add(1)
+		go app.handleConnection(conn)
 	}
 }
 
 func (app *App) handleConnection(conn net.Conn) {
+	c := newConnection(conn)
+	c.parser.maxTargetLength = app.config.MaxURILength
+	c.parser.maxFields = app.config.MaxHeaderCount
+
+	app.connsMu.Lock()
+	app.conns[c] = struct{}{}
+	app.connsMu.Unlock()
+
 	defer func() {
 		slog.Debug("closing the connection given the keep alive header is not present.")
 
+		if err := c.Flush(); err != nil {
+			slog.Debug("failed to send the buffered responses", "error", err)
+		}
 		conn.Close()
-		app.activeConns.Add(-1)
+
+		app.connsMu.Lock()
+		delete(app.conns, c)
+		app.connsMu.Unlock()
+
+		app.releaseConn(conn)
 		app.wg.Done()
 	}()
 
-	err := conn.SetDeadline(time.Now().Add(app.config.IdleTimeout))
-	if err != nil {
-		slog.Error("failed to set deadline for the connection", "error", err)
-		return
-	}
-
-	err = app.resetConnTimeout(conn)
-	if err != nil {
+	if app.negotiatedHTTP2(c) {
+		app.serveHTTP2(c, nil, nil)
 		return
 	}
 
 	for {
-		requestBytes, err := app.readConnection(conn)
+		request, err := app.readConnection(c)
 		if err != nil {
-			// TODO: Improve this in the future.
-			// The `if ne, ok := err.(net.Error); ok && ne.Timeout()` didn't worked.
-			if strings.Contains(err.Error(), "the read deadline was exceeded") {
+			if errors.Is(err, errHTTP2Preface) {
+				app.serveHTTP2(c, nil, nil)
+				return
+			}
+
+			if errors.Is(err, io.EOF) {
+				slog.Debug("reached the EOF of the reading connection, stoping the reads...")
+				return
+			}
+
+			if errors.Is(err, os.ErrDeadlineExceeded) {
 				slog.Debug("the timeout of the connection was reached, closing it", "error", err)
 				return
 			}
 
-			slog.Error("received an error, closing the connection...", "error", err)
+			slog.Debug("failed to read the request", "error", err)
+			if err := app.handleReadError(c, &c.request, err); err != nil {
+				slog.Debug("failed to write the error response", "error", err)
+			}
 			return
 		}
 
-		if len(requestBytes) == 0 {
-			slog.Debug("received an empty request, skipping the reads...")
-			continue
+		if app.upgradeHTTP2(c, request) {
+			return
 		}
 
-		request, err := NewRequest(requestBytes)
-		if err != nil {
-			slog.Debug("failed to parse the request", "error", err)
+		if err := c.SetWriteDeadline(deadline(time.Now(), app.config.WriteTimeout)); err != nil {
+			slog.Debug("failed to set the write deadline", "error", err)
 			return
 		}
 
-		response := app.handleRequest(request)
-		_, err = conn.Write(response)
+		err = app.handleRequest(c, request)
 		if err != nil {
 			slog.Error("failed to write response in the connection", "error", err)
 			return
 		}
 
-		if app.shouldKeepAlive(request) {
-			app.resetConnTimeout(conn)
-			continue
-		} else {
-			app.resetConnTimeout(conn)
+		if c.closing {
+			return
+		}
+
+		if !request.body.drain() {
+			slog.Debug("failed to drain the request body, closing the connection...", "error", request.body.failure())
 			return
 		}
 	}
 }
 
-func (app *App) resetConnTimeout(conn net.Conn) error {
-	err := conn.SetDeadline(time.Now().Add(app.config.IdleTimeout))
+// deadline returns the time the timeout expires, or no deadline when it's zero.
+func deadline(start time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return start.Add(timeout)
+}
+
+// acquireConn counts a new connection, or returns the status rejecting it when it's over the limits.
+// The connections are only accepted by a goroutine, so the counters can't go over the limits.
+func (app *App) acquireConn(conn net.Conn) int {
+	if app.config.MaxConns > 0 && app.activeConns.Load() >= int64(app.config.MaxConns) {
+		slog.Debug("rejecting the connection over the max number of connections", "remote", conn.RemoteAddr())
+		return StatusServiceUnavailable
+	}
+
+	if app.config.MaxConnsPerIP > 0 {
+		ip := remoteIP(conn)
+
+		app.connsMu.Lock()
+		if app.connsPerIP[ip] >= app.config.MaxConnsPerIP {
+			app.connsMu.Unlock()
+			slog.Debug("rejecting the connection over the max number of connections per IP", "remote", conn.RemoteAddr())
+			return StatusTooManyRequests
+		}
+		app.connsPerIP[ip]++
+		app.connsMu.Unlock()
+	}
+
+	app.activeConns.Add(1)
+	return 0
+}
+
+func (app *App) releaseConn(conn net.Conn) {
+	app.activeConns.Add(-1)
+
+	if app.config.MaxConnsPerIP > 0 {
+		ip := remoteIP(conn)
+
+		app.connsMu.Lock()
+		app.connsPerIP[ip]--
+		if app.connsPerIP[ip] <= 0 {
+			delete(app.connsPerIP, ip)
+		}
+		app.connsMu.Unlock()
+	}
+}
+
+// rejectConnection answers a connection over the limits without reading its request.
+func (app *App) rejectConnection(conn net.Conn, status int) {
+	defer func() {
+		conn.Close()
+		app.wg.Done()
+	}()
+
+	// the client could never read, the answer isn't worth more than a second
+	if err := conn.SetWriteDeadline(time.Now().Add(time.Second)); err != nil {
+		return
+	}
+
+	response := NewResponse(status, nil, []byte{})
+	response.SetHeader("Connection", "close")
+	if err := app.writeResponse(conn, response); err != nil {
+		slog.Debug("failed to reject the connection", "error", err)
+	}
+}
+
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+
+	return addr
+}
+
+// handleReadError answers the client through the error handler when the request or its
+// body couldn't be read because of the syntax, the framing or the size. The connection is
+// closed right after, so errors of the connection itself aren't answered.
+func (app *App) handleReadError(conn *connection, request *Request, err error) error {
+	var requestErr requestError
+	var readErr *Error
+
+	switch {
+	case errors.As(err, &readErr):
+	case errors.As(err, &requestErr), errors.Is(err, errMalformedChunk):
+		readErr = NewError(StatusBadRequest, err.Error())
+	case errors.Is(err, ErrBodyTooLarge):
+		readErr = NewError(StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, errUnsupportedTransferEncoding):
+		readErr = NewError(StatusNotImplemented, err.Error())
+	default:
+		return nil
+	}
+
+	// an HTTP/2 stream is reset instead, the other streams of the connection go on
+	if request.h2 == nil {
+		conn.closing = true
+	}
+
+	ctx := app.newCtx(conn, request, nil, nil, nil)
+	app.handleError(ctx, readErr)
+	if ctx.stream != nil {
+		return ctx.stream.Close()
+	}
+
+	return app.sendResponse(ctx)
+}
+
+// handleError runs the error handler, a failure of the handler itself is answered with 500.
+func (app *App) handleError(ctx *Ctx, err error) {
+	if err := app.config.ErrorHandler(ctx, err); err != nil {
+		slog.Error("failed to handle the error", "error", err)
+		ctx.Response = NewResponse(StatusInternalServerError, nil, []byte{})
+		ctx.Response.preserveCase = app.config.PreserveHeaderCase
+	}
+}
+
+// shouldKeepAlive reports whether the client expects the connection to stay open after the
+// response, HTTP/1.1 connections persist by default and HTTP/1.0 ones only with "keep-alive".
+func (app *App) shouldKeepAlive(req *Request) bool {
+	if req.headers.hasToken("Connection", "close") || req.body.awaitingContinue() {
+		return false
+	}
+
+	if req.Protocol == "HTTP/1.0" {
+		return req.headers.hasToken("Connection", "keep-alive")
+	}
+
+	return true
+}
+
+func (app *App) handleRequest(conn *connection, request *Request) error {
+	if route, values := app.findRoute(request.Method, request.rawPath); route != nil {
+		allHandlers := make([]Handler, 0, len(app.middlewares)+len(route.handlers))
+		allHandlers = append(allHandlers, app.middlewares...)
+		allHandlers = append(allHandlers, route.group.allMiddlewares()...)
+		allHandlers = append(allHandlers, route.handlers...)
+
+		return app.runHandlers(conn, request, route, values, allHandlers)
+	}
+
+	allowed := app.allowedMethods(request.rawPath)
+	if len(allowed) > 0 {
+		if request.Method == MethodOptions && !app.config.DisableAutoOptions {
+			allHandlers := make([]Handler, 0, len(app.middlewares)+1)
+			allHandlers = append(allHandlers, app.middlewares...)
+			allHandlers = append(allHandlers, func(c *Ctx) error {
+				c.Set("Allow", strings.Join(allowed, ", "))
+				return c.SendStatus(StatusNoContent)
+			})
+
+			return app.runHandlers(conn, request, nil, nil, allHandlers)
+		}
+
+		if !app.config.DisableMethodNotAllowed {
+			ctx := app.newCtx(conn, request, nil, nil, nil)
+			ctx.Status(StatusMethodNotAllowed).Set("Allow", strings.Join(allowed, ", "))
+			return app.sendResponse(ctx)
+		}
+	}
+
+	ctx := app.newCtx(conn, request, nil, nil, nil)
+	ctx.Status(StatusNotFound)
+	return app.sendResponse(ctx)
+}
+
+// findRoute returns the route matching the method and the path, a HEAD request
+// without its own route is handled by the GET one and the body is left out.
+func (app *App) findRoute(method, path string) (*node, []string) {
+	if root, ok := app.routes[method]; ok {
+		if route, values := root.find(path); route != nil {
+			return route, values
+		}
+	}
+
+	if method == MethodHead {
+		return app.findRoute(MethodGet, path)
+	}
+
+	return nil, nil
+}
+
+func (app *App) newCtx(conn *connection, request *Request, route *node, values []string, handlers []Handler) *Ctx {
+	response := NewResponse(200, nil, nil)
+	response.preserveCase = app.config.PreserveHeaderCase
+	if request.Protocol == "HTTP/1.0" {
+		// an HTTP/1.0 client could misread the features announced by an HTTP/1.1 status line
+		response.protocol = request.Protocol
+	}
+
+	return &Ctx{
+		Request:  request,
+		Response: response,
+		app:      app,
+		conn:     conn,
+		h2:       request.h2,
+		route:    route,
+		values:   values,
+		handlers: handlers,
+		index:    -1, // because it will be incremented in each c.Next(), therefore the first will be 0.
+	}
+}
+
+func (app *App) runHandlers(conn *connection, request *Request, route *node, values []string, handlers []Handler) error {
+	ctx := app.newCtx(conn, request, route, values, handlers)
+
+	err := ctx.Next()
+	if ctx.stream != nil {
+		if err != nil {
+			// the headers were already sent, so the only option is to drop the connection.
+			return fmt.Errorf("failed while streaming the response: %w", err)
+		}
+		return ctx.stream.Close()
+	}
+
+	// a body that couldn't be read takes precedence, the connection is closed after it anyway.
+	if bodyErr := request.body.failure(); bodyErr != nil {
+		return app.handleReadError(conn, request, bodyErr)
+	}
+
 	if err != nil {
-		slog.Error("failed to set deadline for the connection", "error", err)
+		app.handleError(ctx, err)
+		if ctx.stream != nil {
+			return ctx.stream.Close()
+		}
+	}
+
+	return app.sendResponse(ctx)
+}
+
+// sendResponse writes the response of the context, only the head for HEAD requests.
+func (app *App) sendResponse(ctx *Ctx) error {
+	if ctx.Response.bodyless() {
+		ctx.Response.DelHeader("Content-Length")
+		ctx.Response.body = nil
+	}
+
+	if ctx.h2 != nil {
+		return ctx.h2.writeResponse(ctx.Response, ctx.Request.Method == MethodHead)
+	}
+
+	app.setConnectionHeader(ctx)
+	if ctx.Request.Method == MethodHead {
+		// the headers (Content-Length included) are the same as for a GET, but without the body
+		_, err := ctx.conn.Write(ctx.Response.headBytes())
 		return err
 	}
-	return nil
+
+	return app.writeResponse(ctx.conn, ctx.Response)
 }
 
-func (app *App) shouldKeepAlive(req *Request) bool {
-	return (req.GetHeader("connection") != "close")
+// allowedMethods returns the methods with a route matching the path, used by the "Allow" header.
+func (app *App) allowedMethods(path string) []string {
+	var allowed []string
+	for method, root := range app.routes {
+		if route, _ := root.find(path); route != nil {
+			allowed = append(allowed, method)
+		}
+	}
+
+	if slices.Contains(allowed, MethodGet) && !slices.Contains(allowed, MethodHead) {
+		allowed = append(allowed, MethodHead)
+	}
+
+	if len(allowed) > 0 && !app.config.DisableAutoOptions && !slices.Contains(allowed, MethodOptions) {
+		allowed = append(allowed, MethodOptions)
+	}
+
+	// known methods first in their usual order, the custom ones after
+	slices.SortFunc(allowed, func(a, b string) int {
+		ia, ib := slices.Index(methods, a), slices.Index(methods, b)
+		if ia == -1 {
+			ia = len(methods)
+		}
+		if ib == -1 {
+			ib = len(methods)
+		}
+		if ia != ib {
+			return ia - ib
+		}
+		return strings.Compare(a, b)
+	})
+
+	return allowed
 }
 
-func (app *App) handleRequest(request *Request) (response []byte) {
-	if method, ok := app.routes[request.Method]; ok {
-		if routeHandlers, ok := method[request.Path]; ok {
-			allHandlers := append(app.middlewares, routeHandlers...)
+// setConnectionHeader decides whether the connection stays open after the response and tells the client.
+func (app *App) setConnectionHeader(ctx *Ctx) {
+	maxRequests := app.config.MaxRequestsPerConn
+	if !app.shouldKeepAlive(ctx.Request) || (maxRequests > 0 && ctx.conn.requests >= maxRequests) || app.shuttingDown.Load() {
+		ctx.conn.closing = true
+	}
 
-			ctx := &Ctx{
-				Request:  request,
-				Response: NewResponse(200, nil, nil),
-				handlers: allHandlers,
-				index:    -1, // because it will be incremented in each c.Next(), therefore the first will be 0.
-			}
+	if ctx.conn.closing {
+		ctx.Set("Connection", "close")
+		ctx.Response.DelHeader("Keep-Alive")
+		return
+	}
 
-			if err := ctx.Next(); err != nil {
-				return NewResponse(StatusInternalServerError, nil, []byte{}).ToBytes()
-			}
+	ctx.Set("Connection", "keep-alive")
+
+	keepAlive := "timeout=" + strconv.Itoa(int(app.config.IdleTimeout.Seconds()))
+	if maxRequests > 0 {
+		// the number of requests left, as Apache does
+		keepAlive += ", max=" + strconv.Itoa(maxRequests-ctx.conn.requests)
+	}
+	ctx.Set("Keep-Alive", keepAlive)
+}
+
+func (app *App) writeResponse(conn net.Conn, response *Response) error {
+	_, err := conn.Write(response.ToBytes())
+	return err
+}
+
+// priorKnowledge reports whether the request line being parsed is the one of the HTTP/2 preface,
+// sent by the clients that know the server speaks h2c (RFC 9113 section 3.3).
+func (app *App) priorKnowledge(c *connection) bool {
+	if app.config.DisableHTTP2 {
+		return false
+	}
+
+	// over TLS, HTTP/2 is only negotiated with ALPN
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		return false
+	}
+
+	return bytes.HasPrefix(c.reader.buffered(), []byte("PRI * HTTP/2.0\r"))
+}
 
-			if app.shouldKeepAlive(request) {
-				ctx.Set("connection", "keep-alive")
+// readConnection reads the head of the next request from the connection. The body is left
+// in the reader to be streamed by the handler, exactly Content-Length bytes (or the chunks)
+// are consumed so the next request of a keep-alive connection starts right after it.
+//
+// Between two requests the connection waits up to Config.IdleTimeout, then the head must arrive
+// within Config.ReadHeaderTimeout and the body within Config.ReadTimeout of its first byte.
+func (app *App) readConnection(c *connection) (*Request, error) {
+	request := &c.request
+	request.reset()
+
+	// the first request must be sent within the header timeout, the next ones wait up to the idle timeout
+	start := time.Now()
+	if c.requests == 0 {
+		if err := c.SetReadDeadline(deadline(start, app.config.ReadHeaderTimeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.reader.buffered()) == 0 {
+		if c.requests > 0 {
+			if err := c.SetReadDeadline(deadline(start, app.config.IdleTimeout)); err != nil {
+				return nil, err
 			}
+		}
 
-			return ctx.Response.ToBytes()
+		// waiting for a request, the connection can be closed by the shutdown
+		app.setIdle(c, true)
+		err := c.reader.fill()
+		app.setIdle(c, false)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.requests > 0 {
+			start = time.Now()
 		}
 	}
 
-	return NewResponse(StatusNotFound, nil, []byte{}).ToBytes()
-}
+	if err := c.SetReadDeadline(deadline(start, app.config.ReadHeaderTimeout)); err != nil {
+		return nil, err
+	}
 
-func (app *App) readConnection(conn net.Conn) ([]byte, error) {
-	var buf bytes.Buffer
-	readBuf := make([]byte, 4096)
+	c.parser.reset()
 	for {
-		n, err := conn.Read(readBuf)
-		if err != nil && err == io.EOF {
-			slog.Debug("reached the EOF of the reading connection, stoping the reads...")
+		done, err := c.parser.parse(c.reader.buffered())
+		if err != nil {
+			if err == errUnsupportedProtocol && c.requests == 0 && app.priorKnowledge(c) {
+				return nil, errHTTP2Preface
+			}
+			return nil, err
+		}
+		if c.parser.pos > app.config.MaxHeaderBytes {
+			return nil, errHeaderTooLarge
+		}
+		if done {
 			break
 		}
-		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-			slog.Warn("read deadline exceeded", "remote", conn.RemoteAddr())
-			return nil, fmt.Errorf("the read deadline was exceeded: %v", err)
+
+		err = c.reader.fill()
+		if err == bufio.ErrBufferFull {
+			if !c.reader.grow(app.config.MaxHeaderBytes) {
+				return nil, errHeaderTooLarge
+			}
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && c.parser.started() {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+
+	head := c.reader.buffered()[:c.parser.pos]
+	if err := request.fromHead(string(head), &c.parser); err != nil {
+		return nil, err
+	}
+	c.reader.discard(c.parser.pos)
+	c.requests++
+
+	if err := c.SetReadDeadline(deadline(start, app.config.ReadTimeout)); err != nil {
+		return nil, err
+	}
+
+	if err := request.validateFraming(); err != nil {
+		return nil, err
+	}
+
+	chunked, err := request.chunked()
+	if err != nil {
+		return nil, err
+	}
+
+	if chunked {
+		body := newChunkedReader(c.reader, &request.trailers, app.config.MaxHeaderBytes, app.config.MaxHeaderCount)
+		request.body = newBodyReader(body, -1, app.config.BodyLimit)
+	} else {
+		length, err := request.ContentLength()
+		if err != nil {
+			return nil, err
+		}
+
+		// rejected before "100 Continue", so the client doesn't even send the body
+		if length > app.config.BodyLimit {
+			return nil, ErrBodyTooLarge
 		}
 
-		buf.Write(readBuf[:n])
+		if length > 0 {
+			request.body = newBodyReader(c.reader, length, app.config.BodyLimit)
+		}
+	}
 
-		if bytes.Contains(buf.Bytes(), []byte("\r\n\r\n")) {
-			break
+	// the expectations of HTTP/1.0 requests are ignored (RFC 9110 section 10.1.1)
+	if expect := request.GetHeader("Expect"); expect != "" && request.Protocol != "HTTP/1.0" {
+		if !strings.EqualFold(expect, "100-continue") {
+			return nil, errExpectationFailed
+		}
+		if request.body != nil {
+			request.body.expectContinue(func() error {
+				_, err := io.WriteString(c, "HTTP/1.1 100 Continue\r\n\r\n")
+				return err
+			})
 		}
 	}
 
-	return buf.Bytes(), nil
+	return request, nil
 }
 
 func (app *App) Get(path string, handlers ...Handler) Router {
 	return app.Add(MethodGet, path, handlers...)
 }
 
+func (app *App) Head(path string, handlers ...Handler) Router {
+	return app.Add(MethodHead, path, handlers...)
+}
+
+func (app *App) Post(path string, handlers ...Handler) Router {
+	return app.Add(MethodPost, path, handlers...)
+}
+
+func (app *App) Put(path string, handlers ...Handler) Router {
+	return app.Add(MethodPut, path, handlers...)
+}
+
+func (app *App) Patch(path string, handlers ...Handler) Router {
+	return app.Add(MethodPatch, path, handlers...)
+}
+
+func (app *App) Delete(path string, handlers ...Handler) Router {
+	return app.Add(MethodDelete, path, handlers...)
+}
+
+func (app *App) Connect(path string, handlers ...Handler) Router {
+	return app.Add(MethodConnect, path, handlers...)
+}
+
+func (app *App) Options(path string, handlers ...Handler) Router {
+	return app.Add(MethodOptions, path, handlers...)
+}
+
+func (app *App) Trace(path string, handlers ...Handler) Router {
+	return app.Add(MethodTrace, path, handlers...)
+}
+
+// All registers the handlers for every method.
+func (app *App) All(path string, handlers ...Handler) Router {
+	for _, method := range methods {
+		app.Add(method, path, handlers...)
+	}
+	return app
+}
+
+// Route returns a builder to register several methods for the same path.
+func (app *App) Route(path string) *Route {
+	return &Route{router: app, path: path}
+}
+
 func (app *App) Add(method, path string, handlers ...Handler) Router {
-	app.register(method, path, handlers...)
+	app.register(method, path, nil, handlers...)
 	return app
 }
 
-func (app *App) register(method, path string, handlers ...Handler) {
+// Group creates a group of routes sharing the prefix, the handlers run
+// as middlewares only for the routes registered in the group.
+func (app *App) Group(prefix string, handlers ...Handler) *Group {
+	return newGroup(app, nil, prefix, handlers)
+}
+
+func (app *App) register(method, path string, group *Group, handlers ...Handler) {
 	if len(handlers) == 0 {
 		log.Panic("missing handler when registering a route")
 	}
@@ -226,31 +853,105 @@ func (app *App) register(method, path string, handlers ...Handler) {
 		path = "/" + path
 	}
 
-	app.addRoute(method, path, handlers...)
+	for _, expanded := range expandOptional(path) {
+		app.addRoute(method, expanded, path, group, handlers...)
+	}
 }
 
-func (app *App) addRoute(method string, path string, handlers ...Handler) {
+func (app *App) addRoute(method string, path string, pattern string, group *Group, handlers ...Handler) {
 	if app.routes[method] == nil {
-		app.routes[method] = make(map[string][]Handler)
+		app.routes[method] = newNode()
 	}
 
-	app.routes[method][path] = handlers
+	route, err := app.routes[method].insert(path, handlers)
+	if err != nil {
+		log.Panicf("failed to register the route %s %s: %v", method, pattern, err)
+	}
+	route.group = group
 }
 
-func (app *App) Use(middleware Handler) {
-	app.middlewares = append(app.middlewares, middleware)
+// Use adds middlewares that run before the handlers of every route.
+func (app *App) Use(handlers ...Handler) Router {
+	app.middlewares = append(app.middlewares, handlers...)
+	return app
 }
 
+// Shutdown stops the server, waiting for the requests being handled unless forced,
+// in which case every connection is closed right away. See App.ShutdownWithContext.
 func (app *App) Shutdown(force bool) error {
+	if !force {
+		return app.ShutdownWithContext(context.Background())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := app.ShutdownWithContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// ShutdownWithContext stops the server gracefully: the listener is closed first, then the idle
+// keep-alive connections, and the requests being handled are answered with "Connection: close".
+// When the context is done before they finish, the remaining connections are closed and the
+// error of the context is returned.
+func (app *App) ShutdownWithContext(ctx context.Context) error {
 	slog.Debug("amount of active connections BEFORE closing", "activeConns", app.activeConns.Load())
 
-	close(app.quit)
+	var err error
+	app.lnMu.Lock()
+	if app.shuttingDown.CompareAndSwap(false, true) && app.ln != nil {
+		if closeErr := app.ln.Close(); !errors.Is(closeErr, net.ErrClosed) {
+			err = closeErr
+		}
+		app.lnMu.Unlock()
 
-	if !force {
+		// no connection is added to the wait group once the listener stopped
+		<-app.stopped
+	} else {
+		app.lnMu.Unlock()
+	}
+
+	app.closeConns(true)
+
+	done := make(chan struct{})
+	go func() {
 		app.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		app.closeConns(false)
+		err = ctx.Err()
 	}
 
-	err := app.ln.Close()
 	slog.Debug("amount of active connections AFTER closing", "activeConns", app.activeConns.Load())
 	return err
 }
+
+// setIdle marks a connection waiting for its next request, the idle connections
+// are closed by the shutdown, as well as the ones becoming idle after it.
+func (app *App) setIdle(c *connection, idle bool) {
+	app.connsMu.Lock()
+	defer app.connsMu.Unlock()
+
+	c.idle = idle
+	if idle && app.shuttingDown.Load() {
+		c.Conn.Close()
+	}
+}
+
+// closeConns closes the served connections, only the idle ones or all of them.
+func (app *App) closeConns(onlyIdle bool) {
+	app.connsMu.Lock()
+	defer app.connsMu.Unlock()
+
+	for c := range app.conns {
+		if c.idle || !onlyIdle {
+			c.Conn.Close()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package fast
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrBodyTooLarge is returned while reading a request body bigger than Config.BodyLimit.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// errExpectationFailed is an "Expect" header other than "100-continue", the only one supported.
+var errExpectationFailed = NewError(StatusExpectationFailed, "unsupported expectation")
+
+// bodyReader streams the request body from the connection on demand.
+// It stops at the end of the framing (Content-Length or chunked) and enforces the body limit.
+type bodyReader struct {
+	r     io.Reader
+	left  int64 // bytes left when the length is known, -1 for chunked bodies
+	limit int64
+	read  int64
+	err   error
+
+	// sendContinue is set while the client waits for "100 Continue" before sending the body
+	sendContinue func() error
+}
+
+func newBodyReader(r io.Reader, length, limit int64) *bodyReader {
+	return &bodyReader{
+		r:     r,
+		left:  length,
+		limit: limit,
+	}
+}
+
+// expectContinue sends "100 Continue" on the first read, so the client only sends
+// the body once the handler wants it and a request rejected before costs no upload.
+func (b *bodyReader) expectContinue(send func() error) {
+	b.sendContinue = send
+}
+
+// awaitingContinue reports whether the client is still waiting for "100 Continue".
+func (b *bodyReader) awaitingContinue() bool {
+	return b != nil && b.sendContinue != nil
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	if b.sendContinue != nil {
+		send := b.sendContinue
+		b.sendContinue = nil
+		if err := send(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+
+	if b.left == 0 {
+		b.err = io.EOF
+		return 0, b.err
+	}
+
+	if b.left > 0 && int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.left > 0 {
+		b.left -= int64(n)
+		if err == io.EOF && b.left > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+
+	if b.limit > 0 && b.read > b.limit {
+		err = ErrBodyTooLarge
+	}
+
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// failure returns the error that stopped the body from being read, if any.
+func (b *bodyReader) failure() error {
+	if b == nil || b.err == io.EOF {
+		return nil
+	}
+	return b.err
+}
+
+// drain discards what the handler didn't read, so the next request on
+// the connection starts at the right place. It reports whether it succeeded.
+func (b *bodyReader) drain() bool {
+	if b == nil {
+		return true
+	}
+
+	// without "100 Continue" the client may or may not send the body, so the connection can't be reused
+	if b.awaitingContinue() {
+		return false
+	}
+
+	if b.err == nil {
+		_, _ = io.Copy(io.Discard, b)
+	}
+	return b.err == io.EOF
+}
@@ -0,0 +1,258 @@
+package fast
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+)
+
+var (
+	errMalformedChunk              = errors.New("malformed chunked encoding")
+	errUnsupportedTransferEncoding = errors.New("unsupported transfer encoding")
+)
+
+// lineReader is implemented by bufio.Reader and by the reader of the connections.
+type lineReader interface {
+	io.Reader
+	ReadSlice(delim byte) ([]byte, error)
+}
+
+// chunkedReader decodes a body sent with "Transfer-Encoding: chunked" (RFC 9112 section 7.1).
+// The chunk extensions are ignored and the trailer fields are collected once the last chunk is read,
+// they are limited as the header fields since they end up in the request as well.
+type chunkedReader struct {
+	r        lineReader
+	left     int64 // bytes left to read from the current chunk
+	trailers *Header
+	err      error
+
+	maxTrailerBytes  int
+	maxTrailerFields int
+}
+
+func newChunkedReader(r lineReader, trailers *Header, maxTrailerBytes, maxTrailerFields int) *chunkedReader {
+	return &chunkedReader{
+		r:                r,
+		trailers:         trailers,
+		maxTrailerBytes:  maxTrailerBytes,
+		maxTrailerFields: maxTrailerFields,
+	}
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	if cr.left == 0 {
+		size, err := cr.readChunkSize()
+		if err != nil {
+			cr.err = err
+			return 0, err
+		}
+
+		if size == 0 {
+			cr.err = cr.readTrailers()
+			if cr.err == nil {
+				cr.err = io.EOF
+			}
+			return 0, cr.err
+		}
+		cr.left = size
+	}
+
+	if int64(len(p)) > cr.left {
+		p = p[:cr.left]
+	}
+
+	n, err := cr.r.Read(p)
+	cr.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		cr.err = err
+		return n, err
+	}
+
+	// every chunk ends with a CRLF after its data
+	if cr.left == 0 {
+		if err := cr.readCRLF(); err != nil {
+			cr.err = err
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+func (cr *chunkedReader) readChunkSize() (int64, error) {
+	line, err := cr.readLine()
+	if err != nil {
+		return 0, err
+	}
+
+	// chunk extensions are allowed after the size and aren't used for anything
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+
+	if len(line) == 0 || len(line) > 16 {
+		return 0, errMalformedChunk
+	}
+
+	// only hex digits, strconv.ParseInt alone would accept a sign as "+3" or "-0"
+	for _, b := range line {
+		if !('0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F') {
+			return 0, errMalformedChunk
+		}
+	}
+
+	size, err := strconv.ParseInt(string(line), 16, 64)
+	if err != nil || size < 0 {
+		return 0, errMalformedChunk
+	}
+
+	return size, nil
+}
+
+func (cr *chunkedReader) readTrailers() error {
+	size, count := 0, 0
+	for {
+		line, err := cr.readLine()
+		if err != nil {
+			return err
+		}
+
+		if len(line) == 0 {
+			return nil
+		}
+
+		size += len(line) + 2
+		count++
+		switch {
+		case size > cr.maxTrailerBytes:
+			return errHeaderTooLarge
+		case count > cr.maxTrailerFields:
+			return errTooManyHeaderFields
+		}
+
+		key, value, ok := bytes.Cut(line, []byte(":"))
+		if !ok || !validToken(key) {
+			return errMalformedChunk
+		}
+		cr.trailers.Add(string(key), string(bytes.TrimSpace(value)))
+	}
+}
+
+// validToken reports whether the field name is a non-empty token.
+func validToken(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	for _, c := range key {
+		if !tokenChars[c] {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *chunkedReader) readCRLF() error {
+	line, err := cr.readLine()
+	if err != nil {
+		return err
+	}
+
+	if len(line) != 0 {
+		return errMalformedChunk
+	}
+	return nil
+}
+
+// readLine reads a line terminated by CRLF and returns it without the terminator.
+func (cr *chunkedReader) readLine() ([]byte, error) {
+	line, err := cr.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errMalformedChunk
+	}
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, errMalformedChunk
+	}
+
+	return line[:len(line)-2], nil
+}
+
+// chunkedWriter encodes a response body with "Transfer-Encoding: chunked",
+// each call to Write is sent as a single chunk.
+type chunkedWriter struct {
+	w            io.Writer
+	trailers     func() *Header
+	preserveCase bool
+	identity     bool // the body is sent as is, without chunks nor trailers, for HTTP/1.0 clients
+	err          error
+	closed       bool
+}
+
+func (cw *chunkedWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	if cw.closed {
+		return 0, errors.New("write on a finished stream")
+	}
+
+	// an empty chunk would be read as the end of the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if cw.identity {
+		n, err := cw.w.Write(p)
+		cw.err = err
+		return n, err
+	}
+
+	chunk := make([]byte, 0, len(p)+20)
+	chunk = strconv.AppendInt(chunk, int64(len(p)), 16)
+	chunk = append(chunk, "\r\n"...)
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+
+	if _, err := cw.w.Write(chunk); err != nil {
+		cw.err = err
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close sends the last chunk followed by the trailers.
+func (cw *chunkedWriter) Close() error {
+	if cw.err != nil || cw.closed {
+		return cw.err
+	}
+	cw.closed = true
+
+	if cw.identity {
+		return nil
+	}
+
+	end := []byte("0\r\n")
+	end = cw.trailers().appendFields(end, cw.preserveCase)
+	end = append(end, "\r\n"...)
+
+	_, err := cw.w.Write(end)
+	return err
+}
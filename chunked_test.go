@@ -0,0 +1,94 @@
+package fast
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestChunkedReader decodes the body with trailers limited to 1 KB and 10 fields.
+func newTestChunkedReader(body string) *chunkedReader {
+	return newChunkedReader(bufio.NewReader(strings.NewReader(body)), NewHeader(), 1024, 10)
+}
+
+func TestChunkedReader(t *testing.T) {
+	t.Run("should decode the chunks with extensions and trailers", func(t *testing.T) {
+		body := "4\r\nWiki\r\n7;name=value\r\npedia i\r\nB\r\nn \r\nchunks.\r\n0\r\nExpires: never\r\nX-Checksum: abc\r\n\r\n"
+		reader := newTestChunkedReader(body)
+
+		decoded, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		assert.Equal(t, "Wikipedia in \r\nchunks.", string(decoded))
+		assert.Equal(t, "never", reader.trailers.Get("expires"))
+		assert.Equal(t, "abc", reader.trailers.Get("x-checksum"))
+	})
+
+	t.Run("should fail on malformed framing", func(t *testing.T) {
+		for _, body := range []string{
+			"zz\r\nWiki\r\n0\r\n\r\n",
+			"+3\r\nabc\r\n0\r\n\r\n",
+			"-0\r\n\r\n",
+			"4\r\nWikipedia\r\n0\r\n\r\n",
+			"4\nWiki\n0\n\n",
+			"0\r\nInvalid Trailer\r\n\r\n",
+			"0\r\nInvalid Name: value\r\n\r\n",
+			"0\r\n: value\r\n\r\n",
+		} {
+			reader := newTestChunkedReader(body)
+
+			_, err := io.ReadAll(reader)
+			assert.ErrorIs(t, err, errMalformedChunk, body)
+		}
+	})
+
+	t.Run("should limit the trailers as the header fields", func(t *testing.T) {
+		reader := newTestChunkedReader("1\r\na\r\n0\r\n" + strings.Repeat("X-Name: value\r\n", 11) + "\r\n")
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, errTooManyHeaderFields)
+
+		reader = newTestChunkedReader("1\r\na\r\n0\r\nX-Name: " + strings.Repeat("a", 1024) + "\r\n\r\n")
+		_, err = io.ReadAll(reader)
+		assert.ErrorIs(t, err, errHeaderTooLarge)
+	})
+
+	t.Run("should fail when the body ends before the last chunk", func(t *testing.T) {
+		reader := newTestChunkedReader("4\r\nWi")
+
+		_, err := io.ReadAll(reader)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func TestChunkedWriter(t *testing.T) {
+	t.Run("should encode the writes as chunks readable by the decoder", func(t *testing.T) {
+		var buf strings.Builder
+		writer := &chunkedWriter{
+			w: &buf,
+			trailers: func() *Header {
+				trailers := NewHeader()
+				trailers.Add("x-checksum", "abc")
+				return trailers
+			},
+		}
+
+		for _, part := range []string{"hello", "", " streamed world"} {
+			_, err := writer.Write([]byte(part))
+			require.NoError(t, err)
+		}
+		require.NoError(t, writer.Close())
+
+		assert.Equal(t, "5\r\nhello\r\nf\r\n streamed world\r\n0\r\nX-Checksum: abc\r\n\r\n", buf.String())
+
+		reader := newTestChunkedReader(buf.String())
+		decoded, err := io.ReadAll(reader)
+		require.NoError(t, err)
+
+		assert.Equal(t, "hello streamed world", string(decoded))
+		assert.Equal(t, "abc", reader.trailers.Get("x-checksum"))
+	})
+}
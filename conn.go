@@ -0,0 +1,180 @@
+package fast
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+)
+
+const (
+	defaultReadBufferSize  = 4096
+	defaultWriteBufferSize = 4096
+	defaultMaxHeaderBytes  = 1 << 20
+)
+
+// connection keeps the state of a client connection that is reused by each of its requests.
+//
+// Pipelined requests are parsed one after the other from the bytes left in the reader, and
+// their responses are buffered to be sent together, in the same order, once the server
+// needs to wait for the client.
+type connection struct {
+	net.Conn
+	reader   *connReader
+	writer   *bufio.Writer
+	parser   parser
+	request  Request
+	closing  bool // the connection is closed after the current response
+	requests int  // requests read from the connection
+	idle     bool // waiting for the next request, guarded by the mutex of the App
+}
+
+func newConnection(conn net.Conn) *connection {
+	c := &connection{
+		Conn:   conn,
+		writer: bufio.NewWriterSize(conn, defaultWriteBufferSize),
+	}
+	c.reader = newConnReader(c, defaultReadBufferSize)
+	return c
+}
+
+// Read flushes the buffered responses before reading from the connection, otherwise
+// the client could be waiting for a response while the server waits for the client.
+func (c *connection) Read(p []byte) (int, error) {
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+// Write buffers the response until the next read from the connection or Flush.
+func (c *connection) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+// Flush sends the buffered responses.
+func (c *connection) Flush() error {
+	if c.writer.Buffered() == 0 {
+		return nil
+	}
+
+	return c.writer.Flush()
+}
+
+// connReader buffers the reads of a connection. Unlike a bufio.Reader the buffer grows
+// to fit a whole request head, and the bytes read after a request stay buffered for the next one.
+type connReader struct {
+	rd   io.Reader
+	buf  []byte
+	r, w int // read and write positions in buf
+	err  error
+}
+
+func newConnReader(rd io.Reader, size int) *connReader {
+	return &connReader{
+		rd:  rd,
+		buf: make([]byte, size),
+	}
+}
+
+// buffered returns the bytes read from the connection and not consumed yet.
+func (b *connReader) buffered() []byte {
+	return b.buf[b.r:b.w]
+}
+
+// discard consumes n buffered bytes.
+func (b *connReader) discard(n int) {
+	b.r += n
+	if b.r == b.w {
+		b.r, b.w = 0, 0
+	}
+}
+
+// fill reads once from the connection after the buffered bytes,
+// it fails with bufio.ErrBufferFull when there's no room left.
+func (b *connReader) fill() error {
+	if b.r > 0 {
+		copy(b.buf, b.buf[b.r:b.w])
+		b.w -= b.r
+		b.r = 0
+	}
+
+	if b.w == len(b.buf) {
+		return bufio.ErrBufferFull
+	}
+
+	if b.err != nil {
+		err := b.err
+		b.err = nil
+		return err
+	}
+
+	n, err := b.rd.Read(b.buf[b.w:])
+	b.w += n
+	if n > 0 {
+		// the error is returned by the next fill, once the bytes are consumed
+		b.err = err
+		return nil
+	}
+
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// grow doubles the size of the buffer up to max bytes, reporting whether it grew.
+func (b *connReader) grow(max int) bool {
+	if len(b.buf) >= max {
+		return false
+	}
+
+	size := min(len(b.buf)*2, max)
+	buf := make([]byte, size)
+	b.w = copy(buf, b.buf[b.r:b.w])
+	b.r = 0
+	b.buf = buf
+	return true
+}
+
+func (b *connReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.r == b.w {
+		// big reads skip the buffer
+		if len(p) >= len(b.buf) && b.err == nil {
+			return b.rd.Read(p)
+		}
+
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.buf[b.r:b.w])
+	b.discard(n)
+	return n, nil
+}
+
+// ReadSlice reads until the first occurrence of delim, the returned slice is only
+// valid until the next read. It fails with bufio.ErrBufferFull when the buffer fills before delim.
+func (b *connReader) ReadSlice(delim byte) ([]byte, error) {
+	searched := 0
+	for {
+		if i := bytes.IndexByte(b.buf[b.r+searched:b.w], delim); i >= 0 {
+			line := b.buf[b.r : b.r+searched+i+1]
+			b.discard(len(line))
+			return line, nil
+		}
+		searched = b.w - b.r
+
+		if err := b.fill(); err != nil {
+			line := b.buf[b.r:b.w]
+			b.discard(len(line))
+			return line, err
+		}
+	}
+}
@@ -1,19 +1,110 @@
 package fast
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"io"
+	"strconv"
 	"strings"
 )
 
 type Ctx struct {
 	Request  *Request
 	Response *Response
+	app      *App
+	conn     *connection
+	h2       *http2Stream // stream of an HTTP/2 request
+	stream   io.WriteCloser
+	route    *node
+	values   []string // values of the route params
 	index    int
 	handlers []Handler
 }
 
 func (c *Ctx) Get(key string) string {
-	return c.Request.headers[strings.ToLower(key)]
+	return c.Request.GetHeader(key)
+}
+
+// Params returns the value of a route param, as "id" in "/users/:id" or "*" for the wildcard.
+// The default value is returned when the param is missing, as an optional param that wasn't sent.
+func (c *Ctx) Params(name string, defaultValue ...string) string {
+	if c.route != nil {
+		for i, param := range c.route.params {
+			if param == name && c.values[i] != "" {
+				return c.values[i]
+			}
+		}
+	}
+
+	return defaultOf(defaultValue, "")
+}
+
+// AllParams returns the values of every route param.
+func (c *Ctx) AllParams() map[string]string {
+	params := make(map[string]string)
+	if c.route != nil {
+		for i, param := range c.route.params {
+			params[param] = c.values[i]
+		}
+	}
+
+	return params
+}
+
+// Query returns the first value of a query parameter, or the default value when it's missing.
+func (c *Ctx) Query(key string, defaultValue ...string) string {
+	values := c.Request.GetQuery(key)
+	if len(values) == 0 {
+		return defaultOf(defaultValue, "")
+	}
+
+	return values[0]
+}
+
+// QueryValues returns every value of a query parameter, as in "?tag=a&tag=b".
+func (c *Ctx) QueryValues(key string) []string {
+	return c.Request.GetQuery(key)
+}
+
+// Queries returns the first value of each query parameter.
+func (c *Ctx) Queries() map[string]string {
+	queries := make(map[string]string, len(c.Request.Queries()))
+	for key, values := range c.Request.Queries() {
+		if len(values) > 0 {
+			queries[key] = values[0]
+		}
+	}
+
+	return queries
+}
+
+// QueryInt returns a query parameter as an int, or the default value when it's missing or invalid.
+func (c *Ctx) QueryInt(key string, defaultValue ...int) int {
+	value, err := strconv.Atoi(c.Query(key))
+	if err != nil {
+		return defaultOf(defaultValue, 0)
+	}
+
+	return value
+}
+
+// QueryBool returns a query parameter as a bool, or the default value when it's missing or invalid.
+func (c *Ctx) QueryBool(key string, defaultValue ...bool) bool {
+	value, err := strconv.ParseBool(c.Query(key))
+	if err != nil {
+		return defaultOf(defaultValue, false)
+	}
+
+	return value
+}
+
+func defaultOf[T any](values []T, zero T) T {
+	if len(values) > 0 {
+		return values[0]
+	}
+	return zero
 }
 
 func (c *Ctx) SendString(body string) error {
@@ -21,18 +112,134 @@ func (c *Ctx) SendString(body string) error {
 	return nil
 }
 
+// Set replaces the values of a response header.
 func (c *Ctx) Set(key, val string) {
-	c.Response.SetHeader(strings.ToLower(key), val)
+	c.Response.SetHeader(key, val)
+}
+
+// Append adds a value to a response header without replacing the ones already set,
+// each value is sent in its own line as required by "Set-Cookie".
+func (c *Ctx) Append(key, val string) {
+	c.Response.AddHeader(key, val)
 }
 
 func (c *Ctx) Method() string {
 	return c.Request.Method
 }
 
+// Secure reports whether the request was sent over TLS.
+func (c *Ctx) Secure() bool {
+	_, ok := c.conn.Conn.(*tls.Conn)
+	return ok
+}
+
+// ClientCert returns the certificate the client authenticated with, verified against the
+// certificate authorities of App.ListenMutualTLS, or nil without one. The rest of its chain
+// is in the first of TLSConnectionState().VerifiedChains.
+func (c *Ctx) ClientCert() *x509.Certificate {
+	state := c.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0][0]
+}
+
+// TLSConnectionState returns the state of the TLS connection, nil for plain text connections.
+func (c *Ctx) TLSConnectionState() *tls.ConnectionState {
+	conn, ok := c.conn.Conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	state := conn.ConnectionState()
+	return &state
+}
+
+// Protocol returns the scheme the request was sent with, "https" over TLS and "http" otherwise.
+func (c *Ctx) Protocol() string {
+	if c.Secure() {
+		return "https"
+	}
+	return "http"
+}
+
 func (c *Ctx) Send(body []byte) {
 	c.Response.SetBody(body)
 }
 
+// SendStream copies the reader into the response body as it's read, see Ctx.Writer.
+func (c *Ctx) SendStream(r io.Reader) error {
+	_, err := io.Copy(c.Writer(), r)
+	return err
+}
+
+// Writer sends the status line and the headers right away and returns a writer that
+// streams the body to the client with "Transfer-Encoding: chunked", or in DATA frames with HTTP/2.
+// Headers set after the first call are not sent, use Ctx.SetTrailer for values only known at the end.
+func (c *Ctx) Writer() io.Writer {
+	if c.stream != nil {
+		return c.stream
+	}
+
+	c.Response.LoadStatus()
+	c.Response.DelHeader("Content-Length")
+
+	// HTTP/1.0 clients don't know the chunked coding nor the trailers, the end of the connection ends the body
+	identity := c.Request.Protocol == "HTTP/1.0"
+	if !identity && c.Response.trailers.Len() > 0 {
+		c.Set("Trailer", strings.Join(c.Response.trailers.Keys(), ", "))
+	}
+
+	if c.h2 != nil {
+		c.stream = c.h2.writer(c.Response, c.Request.Method == MethodHead)
+		return c.stream
+	}
+
+	if identity {
+		c.conn.closing = true
+	} else {
+		c.Set("Transfer-Encoding", "chunked")
+	}
+	c.app.setConnectionHeader(c)
+
+	// the responses buffered for the previous pipelined requests go first,
+	// then the stream is written directly so each chunk reaches the client right away
+	if err := c.conn.Flush(); err != nil {
+		c.stream = &chunkedWriter{err: err}
+		return c.stream
+	}
+
+	stream := &chunkedWriter{
+		w:            c.conn.Conn,
+		trailers:     func() *Header { return c.Response.trailers },
+		preserveCase: c.Response.preserveCase,
+		identity:     identity,
+	}
+	if c.Request.Method == MethodHead {
+		// only the headers are sent for HEAD requests
+		stream.w = io.Discard
+	}
+
+	if _, err := c.conn.Conn.Write(c.Response.headBytes()); err != nil {
+		stream.err = err
+	}
+
+	c.stream = stream
+	return c.stream
+}
+
+// SetTrailer sets a field sent after a streamed body. The trailers set
+// before the call to Ctx.Writer are announced in the "Trailer" header.
+func (c *Ctx) SetTrailer(key, val string) {
+	c.Response.SetTrailer(key, val)
+}
+
+// Streaming reports whether the response is being streamed with Ctx.Writer.
+func (c *Ctx) Streaming() bool {
+	return c.stream != nil
+}
+
 func (c *Ctx) SendStatus(status int) error {
 	c.Status(status)
 	return nil
@@ -61,3 +268,25 @@ func (c *Ctx) JSON(data any) error {
 	c.Response.SetBody(raw)
 	return nil
 }
+
+// Body returns the whole request body, reading it from the connection on the first call.
+func (c *Ctx) Body() []byte {
+	if body := c.Request.body; body != nil && body.err == nil {
+		// read errors are answered by the app once the handlers return
+		c.Request.Body, _ = io.ReadAll(body)
+	}
+
+	return c.Request.Body
+}
+
+// BodyReader returns a reader that streams the request body from the connection on demand,
+// it fails with ErrBodyTooLarge after Config.BodyLimit bytes. Whatever is left unread is discarded.
+// A client sending "Expect: 100-continue" only gets "100 Continue" on the first read, so a handler
+// that answers without reading the body rejects the upload before it's sent.
+func (c *Ctx) BodyReader() io.Reader {
+	if c.Request.body != nil && c.Request.Body == nil {
+		return c.Request.body
+	}
+
+	return bytes.NewReader(c.Request.Body)
+}
@@ -0,0 +1,42 @@
+package fast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCtxQuery(t *testing.T) {
+	request, err := NewRequest([]byte("GET /search?q=go+http&page=2&tag=a&tag=b&exact=true&size=big HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	c := &Ctx{Request: request}
+
+	t.Run("should return the first value or the default", func(t *testing.T) {
+		assert.Equal(t, "go http", c.Query("q"))
+		assert.Equal(t, "a", c.Query("tag"))
+		assert.Equal(t, "", c.Query("missing"))
+		assert.Equal(t, "fallback", c.Query("missing", "fallback"))
+	})
+
+	t.Run("should return every value of a repeated key", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "b"}, c.QueryValues("tag"))
+		assert.Equal(t, map[string]string{
+			"q":     "go http",
+			"page":  "2",
+			"tag":   "a",
+			"exact": "true",
+			"size":  "big",
+		}, c.Queries())
+	})
+
+	t.Run("should convert the typed values", func(t *testing.T) {
+		assert.Equal(t, 2, c.QueryInt("page"))
+		assert.Equal(t, 20, c.QueryInt("size", 20))
+		assert.Equal(t, 0, c.QueryInt("missing"))
+		assert.True(t, c.QueryBool("exact"))
+		assert.True(t, c.QueryBool("missing", true))
+		assert.False(t, c.QueryBool("size"))
+	})
+}
@@ -0,0 +1,38 @@
+package fast
+
+import "errors"
+
+// Error is an error answered with its status code, handlers return it
+// to send a status other than 500 through the error handler.
+type Error struct {
+	Code    int
+	Message string
+}
+
+// NewError creates an Error, the message is the status text when it's missing.
+func NewError(code int, message ...string) *Error {
+	return &Error{
+		Code:    code,
+		Message: defaultOf(message, StatusText[code]),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorHandler answers the requests whose handlers failed and the ones that couldn't
+// be read, as a malformed request or one over the limits of the Config.
+type ErrorHandler func(*Ctx, error) error
+
+// DefaultErrorHandler answers an Error with its code and its message as the body,
+// any other error is answered with 500 since its message could leak internal details.
+func DefaultErrorHandler(c *Ctx, err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		c.Set("Content-Type", "text/plain; charset=utf-8")
+		return c.Status(e.Code).SendString(e.Message)
+	}
+
+	return c.SendStatus(StatusInternalServerError)
+}
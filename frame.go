@@ -0,0 +1,259 @@
+package fast
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// frameType is the type of an HTTP/2 frame (RFC 9113 section 6).
+type frameType uint8
+
+const (
+	frameData         frameType = 0x0
+	frameHeaders      frameType = 0x1
+	framePriority     frameType = 0x2
+	frameRSTStream    frameType = 0x3
+	frameSettings     frameType = 0x4
+	framePushPromise  frameType = 0x5
+	framePing         frameType = 0x6
+	frameGoAway       frameType = 0x7
+	frameWindowUpdate frameType = 0x8
+	frameContinuation frameType = 0x9
+)
+
+type frameFlags uint8
+
+const (
+	flagEndStream  frameFlags = 0x1
+	flagAck        frameFlags = 0x1 // SETTINGS and PING
+	flagEndHeaders frameFlags = 0x4
+	flagPadded     frameFlags = 0x8
+	flagPriority   frameFlags = 0x20
+)
+
+const (
+	frameHeaderLength = 9
+	// minMaxFrameSize is the initial max size of the frame payloads, the server never asks for bigger ones
+	minMaxFrameSize = 1 << 14
+	maxMaxFrameSize = 1<<24 - 1
+	// maxWindowSize is the largest flow-control window (RFC 9113 section 6.9.1)
+	maxWindowSize = 1<<31 - 1
+)
+
+// http2ErrCode is the code of RST_STREAM and GOAWAY frames (RFC 9113 section 7).
+type http2ErrCode uint32
+
+const (
+	errCodeNo            http2ErrCode = 0x0
+	errCodeProtocol      http2ErrCode = 0x1
+	errCodeInternal      http2ErrCode = 0x2
+	errCodeFlowControl   http2ErrCode = 0x3
+	errCodeStreamClosed  http2ErrCode = 0x5
+	errCodeFrameSize     http2ErrCode = 0x6
+	errCodeRefusedStream http2ErrCode = 0x7
+	errCodeCompression   http2ErrCode = 0x9
+)
+
+// connectionError ends the connection with a GOAWAY frame.
+type connectionError struct {
+	code   http2ErrCode
+	reason string
+}
+
+func (e connectionError) Error() string {
+	return fmt.Sprintf("HTTP/2 connection error %#x: %s", uint32(e.code), e.reason)
+}
+
+// streamError ends a stream with a RST_STREAM frame, the connection goes on.
+type streamError struct {
+	streamID uint32
+	code     http2ErrCode
+	reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("HTTP/2 stream %d error %#x: %s", e.streamID, uint32(e.code), e.reason)
+}
+
+type settingID uint16
+
+const (
+	settingHeaderTableSize      settingID = 0x1
+	settingEnablePush           settingID = 0x2
+	settingMaxConcurrentStreams settingID = 0x3
+	settingInitialWindowSize    settingID = 0x4
+	settingMaxFrameSize         settingID = 0x5
+	settingMaxHeaderListSize    settingID = 0x6
+)
+
+type setting struct {
+	id    settingID
+	value uint32
+}
+
+// parseSettings reads the payload of a SETTINGS frame, also sent in the HTTP2-Settings header of an upgrade.
+func parseSettings(payload []byte) ([]setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, connectionError{errCodeFrameSize, "SETTINGS length isn't a multiple of 6"}
+	}
+
+	settings := make([]setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		s := setting{
+			id:    settingID(binary.BigEndian.Uint16(payload[i:])),
+			value: binary.BigEndian.Uint32(payload[i+2:]),
+		}
+
+		switch {
+		case s.id == settingEnablePush && s.value > 1:
+			return nil, connectionError{errCodeProtocol, "invalid SETTINGS_ENABLE_PUSH"}
+		case s.id == settingInitialWindowSize && s.value > maxWindowSize:
+			return nil, connectionError{errCodeFlowControl, "invalid SETTINGS_INITIAL_WINDOW_SIZE"}
+		case s.id == settingMaxFrameSize && (s.value < minMaxFrameSize || s.value > maxMaxFrameSize):
+			return nil, connectionError{errCodeProtocol, "invalid SETTINGS_MAX_FRAME_SIZE"}
+		}
+		settings = append(settings, s)
+	}
+
+	return settings, nil
+}
+
+// frame is a frame read from the connection, the payload is only valid until the next read.
+type frame struct {
+	typ      frameType
+	flags    frameFlags
+	streamID uint32
+	payload  []byte
+}
+
+func (f frame) has(flag frameFlags) bool {
+	return f.flags&flag != 0
+}
+
+// framer reads and writes the frames of a connection (RFC 9113 section 4.1).
+// The frames are written to w as a whole, a buffered writer must be flushed to send them.
+type framer struct {
+	r      io.Reader
+	w      io.Writer
+	header [frameHeaderLength]byte
+	buf    []byte
+}
+
+func newFramer(r io.Reader, w io.Writer) *framer {
+	return &framer{r: r, w: w}
+}
+
+// readFrame reads the next frame, a payload over the default max size is a connection error.
+func (fr *framer) readFrame() (frame, error) {
+	if _, err := io.ReadFull(fr.r, fr.header[:]); err != nil {
+		return frame{}, err
+	}
+
+	length := int(fr.header[0])<<16 | int(fr.header[1])<<8 | int(fr.header[2])
+	if length > minMaxFrameSize {
+		return frame{}, connectionError{errCodeFrameSize, "frame larger than SETTINGS_MAX_FRAME_SIZE"}
+	}
+
+	if cap(fr.buf) < length {
+		fr.buf = make([]byte, minMaxFrameSize)
+	}
+	payload := fr.buf[:length]
+	if _, err := io.ReadFull(fr.r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return frame{}, err
+	}
+
+	return frame{
+		typ:      frameType(fr.header[3]),
+		flags:    frameFlags(fr.header[4]),
+		streamID: binary.BigEndian.Uint32(fr.header[5:]) & (1<<31 - 1),
+		payload:  payload,
+	}, nil
+}
+
+func (fr *framer) writeFrame(typ frameType, flags frameFlags, streamID uint32, payload []byte) error {
+	header := [frameHeaderLength]byte{
+		byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)),
+		byte(typ), byte(flags),
+	}
+	binary.BigEndian.PutUint32(header[5:], streamID)
+
+	if _, err := fr.w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := fr.w.Write(payload)
+	return err
+}
+
+func (fr *framer) writeSettings(settings ...setting) error {
+	payload := make([]byte, 0, 6*len(settings))
+	for _, s := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(s.id))
+		payload = binary.BigEndian.AppendUint32(payload, s.value)
+	}
+
+	return fr.writeFrame(frameSettings, 0, 0, payload)
+}
+
+func (fr *framer) writeSettingsAck() error {
+	return fr.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+func (fr *framer) writePing(ack bool, data []byte) error {
+	var flags frameFlags
+	if ack {
+		flags = flagAck
+	}
+	return fr.writeFrame(framePing, flags, 0, data)
+}
+
+func (fr *framer) writeWindowUpdate(streamID uint32, increment uint32) error {
+	return fr.writeFrame(frameWindowUpdate, 0, streamID, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+func (fr *framer) writeRSTStream(streamID uint32, code http2ErrCode) error {
+	return fr.writeFrame(frameRSTStream, 0, streamID, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (fr *framer) writeGoAway(lastStreamID uint32, code http2ErrCode, debug string) error {
+	payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, debug...)
+	return fr.writeFrame(frameGoAway, 0, 0, payload)
+}
+
+func (fr *framer) writeData(streamID uint32, endStream bool, data []byte) error {
+	var flags frameFlags
+	if endStream {
+		flags = flagEndStream
+	}
+	return fr.writeFrame(frameData, flags, streamID, data)
+}
+
+// writeHeaders writes a header block in a HEADERS frame followed by
+// as many CONTINUATION frames as needed to fit the max frame size.
+func (fr *framer) writeHeaders(streamID uint32, endStream bool, block []byte, maxFrameSize int) error {
+	typ := frameHeaders
+	var flags frameFlags
+	if endStream {
+		flags = flagEndStream
+	}
+
+	for first := true; first || len(block) > 0; first = false {
+		fragment := block[:min(len(block), maxFrameSize)]
+		block = block[len(fragment):]
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+
+		if err := fr.writeFrame(typ, flags, streamID, fragment); err != nil {
+			return err
+		}
+		typ, flags = frameContinuation, 0
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package fast
+
+import "strings"
+
+// Group registers routes under a common prefix. Its middlewares run after the ones
+// of the app (and of the parent groups) and only for the routes of the group.
+type Group struct {
+	app         *App
+	parent      *Group
+	prefix      string
+	middlewares []Handler
+}
+
+func newGroup(app *App, parent *Group, prefix string, handlers []Handler) *Group {
+	prefix = strings.Trim(prefix, "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+
+	if parent != nil {
+		prefix = parent.prefix + prefix
+	}
+
+	return &Group{
+		app:         app,
+		parent:      parent,
+		prefix:      prefix,
+		middlewares: handlers,
+	}
+}
+
+func (g *Group) Get(path string, handlers ...Handler) Router {
+	return g.Add(MethodGet, path, handlers...)
+}
+
+func (g *Group) Head(path string, handlers ...Handler) Router {
+	return g.Add(MethodHead, path, handlers...)
+}
+
+func (g *Group) Post(path string, handlers ...Handler) Router {
+	return g.Add(MethodPost, path, handlers...)
+}
+
+func (g *Group) Put(path string, handlers ...Handler) Router {
+	return g.Add(MethodPut, path, handlers...)
+}
+
+func (g *Group) Patch(path string, handlers ...Handler) Router {
+	return g.Add(MethodPatch, path, handlers...)
+}
+
+func (g *Group) Delete(path string, handlers ...Handler) Router {
+	return g.Add(MethodDelete, path, handlers...)
+}
+
+func (g *Group) Connect(path string, handlers ...Handler) Router {
+	return g.Add(MethodConnect, path, handlers...)
+}
+
+func (g *Group) Options(path string, handlers ...Handler) Router {
+	return g.Add(MethodOptions, path, handlers...)
+}
+
+func (g *Group) Trace(path string, handlers ...Handler) Router {
+	return g.Add(MethodTrace, path, handlers...)
+}
+
+// All registers the handlers for every method.
+func (g *Group) All(path string, handlers ...Handler) Router {
+	for _, method := range methods {
+		g.Add(method, path, handlers...)
+	}
+	return g
+}
+
+// Route returns a builder to register several methods for the same path.
+func (g *Group) Route(path string) *Route {
+	return &Route{router: g, path: path}
+}
+
+func (g *Group) Add(method, path string, handlers ...Handler) Router {
+	g.app.register(method, g.prefix+"/"+strings.TrimPrefix(path, "/"), g, handlers...)
+	return g
+}
+
+// Group creates a nested group, the prefix is appended to the one of this group.
+func (g *Group) Group(prefix string, handlers ...Handler) *Group {
+	return newGroup(g.app, g, prefix, handlers)
+}
+
+// Use adds middlewares that run before the handlers of every route of the group, including the nested groups.
+func (g *Group) Use(handlers ...Handler) Router {
+	g.middlewares = append(g.middlewares, handlers...)
+	return g
+}
+
+// allMiddlewares returns the middlewares of the parent groups followed by the ones of this group.
+func (g *Group) allMiddlewares() []Handler {
+	if g == nil {
+		return nil
+	}
+
+	return append(g.parent.allMiddlewares(), g.middlewares...)
+}
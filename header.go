@@ -0,0 +1,173 @@
+package fast
+
+import (
+	"iter"
+	"strings"
+)
+
+// Header is an ordered collection of header fields where a key can have several values,
+// as "Set-Cookie" or "X-Forwarded-For". The keys are compared ignoring the case.
+type Header struct {
+	fields []headerField
+}
+
+type headerField struct {
+	key   string
+	value string
+}
+
+func NewHeader() *Header {
+	return &Header{}
+}
+
+// Add appends a value to the key, keeping the ones already set.
+func (h *Header) Add(key, value string) {
+	h.fields = append(h.fields, headerField{key: key, value: value})
+}
+
+// Set replaces every value of the key, the field keeps the position of its first value.
+func (h *Header) Set(key, value string) {
+	for i := range h.fields {
+		if strings.EqualFold(h.fields[i].key, key) {
+			h.fields[i] = headerField{key: key, value: value}
+			h.del(key, i+1)
+			return
+		}
+	}
+
+	h.Add(key, value)
+}
+
+// Get returns the first value of the key, or an empty string when it's missing.
+func (h *Header) Get(key string) string {
+	for _, field := range h.fields {
+		if strings.EqualFold(field.key, key) {
+			return field.value
+		}
+	}
+	return ""
+}
+
+// Values returns every value of the key, in the order they were added.
+func (h *Header) Values(key string) []string {
+	var values []string
+	for _, field := range h.fields {
+		if strings.EqualFold(field.key, key) {
+			values = append(values, field.value)
+		}
+	}
+	return values
+}
+
+func (h *Header) Has(key string) bool {
+	for _, field := range h.fields {
+		if strings.EqualFold(field.key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Del removes every value of the key.
+func (h *Header) Del(key string) {
+	h.del(key, 0)
+}
+
+func (h *Header) del(key string, from int) {
+	fields := h.fields[:from]
+	for _, field := range h.fields[from:] {
+		if !strings.EqualFold(field.key, key) {
+			fields = append(fields, field)
+		}
+	}
+
+	clear(h.fields[len(fields):])
+	h.fields = fields
+}
+
+// Len returns the number of fields, a key with several values counts once per value.
+func (h *Header) Len() int {
+	return len(h.fields)
+}
+
+// Keys returns each key once, in the order they were added.
+func (h *Header) Keys() []string {
+	var keys []string
+	for _, field := range h.fields {
+		if !containsFold(keys, field.key) {
+			keys = append(keys, field.key)
+		}
+	}
+	return keys
+}
+
+// All iterates over every field in the order they were added.
+func (h *Header) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, field := range h.fields {
+			if !yield(field.key, field.value) {
+				return
+			}
+		}
+	}
+}
+
+// hasToken reports whether the comma-separated values of the key contain the token,
+// compared case-insensitively as in "Connection: keep-alive, Upgrade".
+func (h *Header) hasToken(key, token string) bool {
+	for _, value := range h.Values(key) {
+		for part := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// appendFields appends the fields in the wire format, one "Key: value\r\n" line per value.
+// The keys are sent in the canonical form ("content-type" as "Content-Type") unless preserveCase is set.
+func (h *Header) appendFields(dst []byte, preserveCase bool) []byte {
+	for _, field := range h.fields {
+		if preserveCase {
+			dst = append(dst, field.key...)
+		} else {
+			dst = appendCanonicalKey(dst, field.key)
+		}
+		dst = append(dst, ": "...)
+		dst = append(dst, field.value...)
+		dst = append(dst, "\r\n"...)
+	}
+	return dst
+}
+
+// CanonicalHeaderKey returns the key with the first letter and every letter
+// after a hyphen in upper case and the rest in lower case, as "X-Request-Id".
+func CanonicalHeaderKey(key string) string {
+	return string(appendCanonicalKey(make([]byte, 0, len(key)), key))
+}
+
+func appendCanonicalKey(dst []byte, key string) []byte {
+	upper := true
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if upper && 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+		upper = c == '-'
+	}
+	return dst
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
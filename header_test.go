@@ -0,0 +1,77 @@
+package fast
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeader(t *testing.T) {
+	t.Run("should keep every value of a repeated key in order", func(t *testing.T) {
+		h := NewHeader()
+		h.Add("Set-Cookie", "a=1")
+		h.Add("Vary", "Accept")
+		h.Add("set-cookie", "b=2")
+
+		assert.Equal(t, "a=1", h.Get("SET-COOKIE"))
+		assert.Equal(t, []string{"a=1", "b=2"}, h.Values("Set-Cookie"))
+		assert.Equal(t, []string{"Set-Cookie", "Vary"}, h.Keys())
+		assert.Equal(t, 3, h.Len())
+	})
+
+	t.Run("should replace the values keeping the position of the first one", func(t *testing.T) {
+		h := NewHeader()
+		h.Add("Via", "1.1 a")
+		h.Add("Content-Type", "text/plain")
+		h.Add("Via", "1.1 b")
+		h.Set("via", "1.1 c")
+
+		var keys, values []string
+		for key, value := range h.All() {
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+
+		assert.Equal(t, []string{"via", "Content-Type"}, keys)
+		assert.Equal(t, []string{"1.1 c", "text/plain"}, values)
+	})
+
+	t.Run("should delete every value of the key", func(t *testing.T) {
+		h := NewHeader()
+		h.Add("Accept", "text/html")
+		h.Add("Host", "localhost")
+		h.Add("accept", "application/json")
+		h.Del("ACCEPT")
+
+		assert.False(t, h.Has("Accept"))
+		assert.Empty(t, h.Values("Accept"))
+		assert.Equal(t, map[string]string{"Host": "localhost"}, maps.Collect(h.All()))
+	})
+}
+
+func TestHeaderTokens(t *testing.T) {
+	h := NewHeader()
+	h.Add("Connection", "Keep-Alive, Upgrade")
+	h.Add("connection", " TE ")
+
+	assert.True(t, h.hasToken("Connection", "keep-alive"))
+	assert.True(t, h.hasToken("Connection", "upgrade"))
+	assert.True(t, h.hasToken("Connection", "te"))
+	assert.False(t, h.hasToken("Connection", "close"))
+	assert.False(t, h.hasToken("Upgrade", "h2c"))
+}
+
+func TestCanonicalHeaderKey(t *testing.T) {
+	tests := map[string]string{
+		"content-type":     "Content-Type",
+		"X-REQUEST-ID":     "X-Request-Id",
+		"www-authenticate": "Www-Authenticate",
+		"etag":             "Etag",
+		"x-1-b":            "X-1-B",
+	}
+
+	for key, expected := range tests {
+		assert.Equal(t, expected, CanonicalHeaderKey(key), key)
+	}
+}
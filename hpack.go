@@ -0,0 +1,399 @@
+package fast
+
+import "errors"
+
+// defaultHeaderTableSize is the size of the dynamic table until SETTINGS_HEADER_TABLE_SIZE changes it.
+const defaultHeaderTableSize = 4096
+
+// errInvalidHeaderBlock is a header block that can't be decoded, the connection can't
+// continue since the dynamic table of the client and the one of the server differ from then on.
+var errInvalidHeaderBlock = errors.New("invalid HPACK header block")
+
+// hpackStaticTable is the static table of RFC 7541 appendix A, the index 1 is the first entry.
+var hpackStaticTable = [...]headerField{
+	{":authority", ""},
+	{":method", "GET"},
+	{":method", "POST"},
+	{":path", "/"},
+	{":path", "/index.html"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "200"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "304"},
+	{":status", "400"},
+	{":status", "404"},
+	{":status", "500"},
+	{"accept-charset", ""},
+	{"accept-encoding", "gzip, deflate"},
+	{"accept-language", ""},
+	{"accept-ranges", ""},
+	{"accept", ""},
+	{"access-control-allow-origin", ""},
+	{"age", ""},
+	{"allow", ""},
+	{"authorization", ""},
+	{"cache-control", ""},
+	{"content-disposition", ""},
+	{"content-encoding", ""},
+	{"content-language", ""},
+	{"content-length", ""},
+	{"content-location", ""},
+	{"content-range", ""},
+	{"content-type", ""},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"expect", ""},
+	{"expires", ""},
+	{"from", ""},
+	{"host", ""},
+	{"if-match", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"if-range", ""},
+	{"if-unmodified-since", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"max-forwards", ""},
+	{"proxy-authenticate", ""},
+	{"proxy-authorization", ""},
+	{"range", ""},
+	{"referer", ""},
+	{"refresh", ""},
+	{"retry-after", ""},
+	{"server", ""},
+	{"set-cookie", ""},
+	{"strict-transport-security", ""},
+	{"transfer-encoding", ""},
+	{"user-agent", ""},
+	{"vary", ""},
+	{"via", ""},
+	{"www-authenticate", ""},
+}
+
+// hpackTable is the dynamic table of an HPACK context (RFC 7541 section 2.3.2),
+// the newest entry is the last one and the oldest ones are evicted first.
+type hpackTable struct {
+	fields  []headerField
+	size    int // sum of the sizes of the entries
+	maxSize int
+}
+
+// entrySize is the size of a field in the dynamic table, its length plus an overhead of 32 bytes.
+func entrySize(key, value string) int {
+	return len(key) + len(value) + 32
+}
+
+func (t *hpackTable) add(key, value string) {
+	t.fields = append(t.fields, headerField{key: key, value: value})
+	t.size += entrySize(key, value)
+	t.evict()
+}
+
+func (t *hpackTable) setMaxSize(size int) {
+	t.maxSize = size
+	t.evict()
+}
+
+// evict removes the oldest entries until the table fits its max size,
+// an entry bigger than the table empties it.
+func (t *hpackTable) evict() {
+	n := 0
+	for t.size > t.maxSize {
+		t.size -= entrySize(t.fields[n].key, t.fields[n].value)
+		n++
+	}
+
+	if n > 0 {
+		copy(t.fields, t.fields[n:])
+		clear(t.fields[len(t.fields)-n:])
+		t.fields = t.fields[:len(t.fields)-n]
+	}
+}
+
+// get returns the field of an index of the static table followed by the dynamic one.
+func (t *hpackTable) get(index uint64) (headerField, bool) {
+	if index == 0 {
+		return headerField{}, false
+	}
+
+	if index <= uint64(len(hpackStaticTable)) {
+		return hpackStaticTable[index-1], true
+	}
+
+	index -= uint64(len(hpackStaticTable)) + 1
+	if index >= uint64(len(t.fields)) {
+		return headerField{}, false
+	}
+	return t.fields[len(t.fields)-1-int(index)], true
+}
+
+// search returns the index of the field, or of its key when exact is false, 0 when the key isn't found.
+func (t *hpackTable) search(key, value string) (index uint64, exact bool) {
+	for i, field := range hpackStaticTable {
+		if field.key == key {
+			if field.value == value {
+				return uint64(i + 1), true
+			}
+			if index == 0 {
+				index = uint64(i + 1)
+			}
+		}
+	}
+
+	for i := len(t.fields) - 1; i >= 0; i-- {
+		if t.fields[i].key == key {
+			dynamic := uint64(len(hpackStaticTable) + len(t.fields) - i)
+			if t.fields[i].value == value {
+				return dynamic, true
+			}
+			if index == 0 {
+				index = dynamic
+			}
+		}
+	}
+
+	return index, false
+}
+
+// hpackDecoder decodes the header blocks of a connection (RFC 7541), the blocks
+// must be decoded in the order they were received since they share the dynamic table.
+type hpackDecoder struct {
+	table hpackTable
+	// maxTableSize is the limit of the dynamic table announced with SETTINGS_HEADER_TABLE_SIZE
+	maxTableSize int
+	buf          []byte // decoded Huffman strings
+}
+
+func newHPACKDecoder(maxTableSize int) *hpackDecoder {
+	return &hpackDecoder{
+		table:        hpackTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+	}
+}
+
+// decode calls emit with each field of the header block, in order.
+func (d *hpackDecoder) decode(block []byte, emit func(key, value string)) error {
+	// the dynamic table size updates are only allowed at the start of a block
+	sizeUpdates := true
+
+	for len(block) > 0 {
+		var err error
+		switch b := block[0]; {
+		case b&0x80 != 0: // indexed field
+			var index uint64
+			if index, block, err = readHPACKInt(block, 7); err != nil {
+				return err
+			}
+
+			field, ok := d.table.get(index)
+			if !ok {
+				return errInvalidHeaderBlock
+			}
+			emit(field.key, field.value)
+
+		case b&0xc0 == 0x40: // literal field added to the dynamic table
+			var key, value string
+			if key, value, block, err = d.readLiteral(block, 6); err != nil {
+				return err
+			}
+			d.table.add(key, value)
+			emit(key, value)
+
+		case b&0xe0 == 0x20: // dynamic table size update
+			var size uint64
+			if size, block, err = readHPACKInt(block, 5); err != nil {
+				return err
+			}
+			if !sizeUpdates || size > uint64(d.maxTableSize) {
+				return errInvalidHeaderBlock
+			}
+			d.table.setMaxSize(int(size))
+			continue
+
+		default: // literal field not added to the dynamic table, never indexed or not
+			var key, value string
+			if key, value, block, err = d.readLiteral(block, 4); err != nil {
+				return err
+			}
+			emit(key, value)
+		}
+
+		sizeUpdates = false
+	}
+
+	return nil
+}
+
+// readLiteral reads a literal field whose key is indexed with a prefix of n bits or follows as a string.
+func (d *hpackDecoder) readLiteral(block []byte, n uint) (key, value string, rest []byte, err error) {
+	index, block, err := readHPACKInt(block, n)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	if index > 0 {
+		field, ok := d.table.get(index)
+		if !ok {
+			return "", "", nil, errInvalidHeaderBlock
+		}
+		key = field.key
+	} else if key, block, err = d.readString(block); err != nil {
+		return "", "", nil, err
+	}
+
+	value, block, err = d.readString(block)
+	return key, value, block, err
+}
+
+// readString reads a string literal, Huffman-encoded or not (RFC 7541 section 5.2).
+func (d *hpackDecoder) readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, errInvalidHeaderBlock
+	}
+	huffman := block[0]&0x80 != 0
+
+	length, block, err := readHPACKInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(block)) {
+		return "", nil, errInvalidHeaderBlock
+	}
+
+	data, block := block[:length], block[length:]
+	if !huffman {
+		return string(data), block, nil
+	}
+
+	d.buf, err = appendHuffmanDecoded(d.buf[:0], data)
+	if err != nil {
+		return "", nil, errInvalidHeaderBlock
+	}
+	return string(d.buf), block, nil
+}
+
+// readHPACKInt reads an integer with a prefix of n bits (RFC 7541 section 5.1).
+func readHPACKInt(block []byte, n uint) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, errInvalidHeaderBlock
+	}
+
+	max := uint64(1)<<n - 1
+	value := uint64(block[0]) & max
+	if value < max {
+		return value, block[1:], nil
+	}
+
+	var shift uint
+	for i := 1; i < len(block); i++ {
+		value += uint64(block[i]&0x7f) << shift
+		if block[i]&0x80 == 0 {
+			return value, block[i+1:], nil
+		}
+
+		// no field is anywhere near this size, only a malicious block
+		shift += 7
+		if shift > 56 {
+			return 0, nil, errInvalidHeaderBlock
+		}
+	}
+
+	return 0, nil, errInvalidHeaderBlock
+}
+
+// hpackEncoder encodes the header blocks of a connection, every field goes to the dynamic
+// table but the sensitive ones, and the strings are Huffman-encoded when it's shorter.
+type hpackEncoder struct {
+	table hpackTable
+	// minTableSize is the smallest size the table had since the last block, when it changed
+	minTableSize int
+	sizeChanged  bool
+}
+
+func newHPACKEncoder() *hpackEncoder {
+	return &hpackEncoder{table: hpackTable{maxSize: defaultHeaderTableSize}}
+}
+
+// setMaxTableSize applies the SETTINGS_HEADER_TABLE_SIZE of the peer,
+// the table never grows over the default size to bound its memory.
+func (e *hpackEncoder) setMaxTableSize(size int) {
+	size = min(size, defaultHeaderTableSize)
+	if size == e.table.maxSize {
+		return
+	}
+
+	if !e.sizeChanged || size < e.minTableSize {
+		e.minTableSize = size
+	}
+	e.sizeChanged = true
+	e.table.setMaxSize(size)
+}
+
+// appendField appends the field to a header block, the key must be in lower case.
+func (e *hpackEncoder) appendField(block []byte, key, value string) []byte {
+	if e.sizeChanged {
+		// the decoder must see the smallest size to evict the same entries (RFC 7541 section 4.2)
+		if e.minTableSize < e.table.maxSize {
+			block = appendHPACKInt(block, 0x20, 5, uint64(e.minTableSize))
+		}
+		block = appendHPACKInt(block, 0x20, 5, uint64(e.table.maxSize))
+		e.sizeChanged = false
+	}
+
+	index, exact := e.table.search(key, value)
+	if exact {
+		return appendHPACKInt(block, 0x80, 7, index)
+	}
+
+	switch {
+	case sensitiveField(key):
+		block = appendHPACKInt(block, 0x10, 4, index)
+	case entrySize(key, value) <= e.table.maxSize:
+		block = appendHPACKInt(block, 0x40, 6, index)
+		e.table.add(key, value)
+	default:
+		block = appendHPACKInt(block, 0x00, 4, index)
+	}
+
+	if index == 0 {
+		block = appendHPACKString(block, key)
+	}
+	return appendHPACKString(block, value)
+}
+
+// sensitiveField reports whether the values of the key must never be indexed, even by the
+// intermediaries, since they are secrets that could be guessed from the compression (RFC 7541 section 7.1).
+func sensitiveField(key string) bool {
+	return key == "authorization" || key == "proxy-authorization" || key == "set-cookie"
+}
+
+// appendHPACKInt appends an integer with a prefix of n bits, the other bits of the first byte are flags.
+func appendHPACKInt(block []byte, flags byte, n uint, value uint64) []byte {
+	max := uint64(1)<<n - 1
+	if value < max {
+		return append(block, flags|byte(value))
+	}
+
+	block = append(block, flags|byte(max))
+	value -= max
+	for value >= 0x80 {
+		block = append(block, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(block, byte(value))
+}
+
+// appendHPACKString appends a string literal, Huffman-encoded when it's shorter.
+func appendHPACKString(block []byte, s string) []byte {
+	if length := huffmanLength(s); length < len(s) {
+		block = appendHPACKInt(block, 0x80, 7, uint64(length))
+		return appendHuffman(block, s)
+	}
+
+	block = appendHPACKInt(block, 0, 7, uint64(len(s)))
+	return append(block, s...)
+}
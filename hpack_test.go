@@ -0,0 +1,188 @@
+package fast
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hpackRequests are the requests of RFC 7541 appendix C.3 and C.4, sharing a dynamic table.
+var hpackRequests = []struct {
+	plain   string
+	huffman string
+	fields  []headerField
+}{
+	{
+		plain:   "828684410f7777772e6578616d706c652e636f6d",
+		huffman: "828684418cf1e3c2e5f23a6ba0ab90f4ff",
+		fields: []headerField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+		},
+	},
+	{
+		plain:   "828684be58086e6f2d6361636865",
+		huffman: "828684be5886a8eb10649cbf",
+		fields: []headerField{
+			{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+			{"cache-control", "no-cache"},
+		},
+	},
+	{
+		plain:   "828785bf400a637573746f6d2d6b65790c637573746f6d2d76616c7565",
+		huffman: "828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+		fields: []headerField{
+			{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"},
+			{"custom-key", "custom-value"},
+		},
+	},
+}
+
+func decodeBlock(t *testing.T, d *hpackDecoder, block string) []headerField {
+	t.Helper()
+
+	data, err := hex.DecodeString(block)
+	require.NoError(t, err)
+
+	var fields []headerField
+	require.NoError(t, d.decode(data, func(key, value string) {
+		fields = append(fields, headerField{key, value})
+	}))
+	return fields
+}
+
+func TestHPACKDecoder(t *testing.T) {
+	t.Run("should decode the literals and the indexed fields", func(t *testing.T) {
+		plain, huffman := newHPACKDecoder(defaultHeaderTableSize), newHPACKDecoder(defaultHeaderTableSize)
+		for _, request := range hpackRequests {
+			assert.Equal(t, request.fields, decodeBlock(t, plain, request.plain))
+			assert.Equal(t, request.fields, decodeBlock(t, huffman, request.huffman))
+		}
+
+		// custom-key: custom-value, cache-control: no-cache and :authority: www.example.com
+		for _, d := range []*hpackDecoder{plain, huffman} {
+			assert.Len(t, d.table.fields, 3)
+			assert.Equal(t, 164, d.table.size)
+		}
+	})
+
+	t.Run("should evict the oldest entries and apply the size updates", func(t *testing.T) {
+		d := newHPACKDecoder(defaultHeaderTableSize)
+		decodeBlock(t, d, hpackRequests[0].plain)
+		decodeBlock(t, d, hpackRequests[1].plain)
+
+		// the size update to 109 only keeps cache-control: no-cache
+		fields := decodeBlock(t, d, "3f4e"+"be")
+		assert.Equal(t, []headerField{{"cache-control", "no-cache"}}, fields)
+		assert.Equal(t, 109, d.table.maxSize)
+		assert.Len(t, d.table.fields, 1)
+	})
+
+	t.Run("should fail on invalid blocks", func(t *testing.T) {
+		for _, block := range []string{
+			"80",                       // index 0
+			"be",                       // empty dynamic table
+			"ff8080808080808080808001", // integer overflow
+			"ff",                       // truncated integer
+			"4005",                     // truncated string
+			"82" + "3f4f",              // size update after a field
+			"3fe21f",                   // size update over the setting
+			"0081ff0161",               // Huffman padding longer than 7 bits
+			"00810001",                 // Huffman padding not made of ones
+		} {
+			data, err := hex.DecodeString(block)
+			require.NoError(t, err)
+
+			err = newHPACKDecoder(defaultHeaderTableSize).decode(data, func(key, value string) {})
+			assert.ErrorIs(t, err, errInvalidHeaderBlock, block)
+		}
+	})
+}
+
+func TestHPACKEncoder(t *testing.T) {
+	t.Run("should encode the fields as RFC 7541", func(t *testing.T) {
+		e := newHPACKEncoder()
+		for _, request := range hpackRequests {
+			var block []byte
+			for _, field := range request.fields {
+				block = e.appendField(block, field.key, field.value)
+			}
+			assert.Equal(t, request.huffman, hex.EncodeToString(block))
+		}
+	})
+
+	t.Run("should never index the sensitive fields", func(t *testing.T) {
+		e := newHPACKEncoder()
+		block := e.appendField(nil, "set-cookie", "session=secret")
+		block = e.appendField(block, "authorization", "Bearer secret")
+
+		assert.Empty(t, e.table.fields)
+		assert.Equal(t, byte(0x1f), block[0]) // never indexed, set-cookie is the entry 55
+	})
+
+	t.Run("should be decoded after the table size changes", func(t *testing.T) {
+		e, d := newHPACKEncoder(), newHPACKDecoder(defaultHeaderTableSize)
+		fields := []headerField{
+			{":status", "200"},
+			{"content-type", "text/plain; charset=utf-8"},
+			{"x-request-id", strings.Repeat("a", 100)},
+			{"set-cookie", "session=secret"},
+		}
+
+		for _, size := range []int{defaultHeaderTableSize, 0, 64, 8192} {
+			e.setMaxTableSize(size)
+			e.setMaxTableSize(size + 1)
+
+			for range 2 {
+				var block []byte
+				for _, field := range fields {
+					block = e.appendField(block, field.key, field.value)
+				}
+				assert.Equal(t, fields, decodeBlock(t, d, hex.EncodeToString(block)), size)
+			}
+			assert.Equal(t, e.table.fields, d.table.fields, size)
+		}
+	})
+}
+
+func TestHPACKInt(t *testing.T) {
+	// RFC 7541 appendix C.1
+	tests := []struct {
+		prefix uint
+		value  uint64
+		data   string
+	}{
+		{5, 10, "0a"},
+		{5, 1337, "1f9a0a"},
+		{8, 42, "2a"},
+		{7, 127, "7f00"},
+	}
+
+	for _, tt := range tests {
+		data := appendHPACKInt(nil, 0, tt.prefix, tt.value)
+		assert.Equal(t, tt.data, hex.EncodeToString(data))
+
+		value, rest, err := readHPACKInt(data, tt.prefix)
+		require.NoError(t, err)
+		assert.Equal(t, tt.value, value)
+		assert.Empty(t, rest)
+	}
+}
+
+func TestHuffman(t *testing.T) {
+	var all strings.Builder
+	for b := range 256 {
+		all.WriteByte(byte(b))
+	}
+
+	for _, s := range []string{"", "www.example.com", "Mon, 21 Oct 2013 20:13:21 GMT", all.String()} {
+		encoded := appendHuffman(nil, s)
+		assert.Len(t, encoded, huffmanLength(s))
+
+		decoded, err := appendHuffmanDecoded(nil, encoded)
+		require.NoError(t, err)
+		assert.Equal(t, s, string(decoded))
+	}
+}
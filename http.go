@@ -3,101 +3,286 @@ package fast
 import (
 	"errors"
 	"fmt"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+// Request is the request being handled. The requests of a connection reuse the same
+// Request, so it must not be retained after the handlers return.
 type Request struct {
 	Method   string
-	Path     string
+	Path     string // percent-decoded, without the query
+	rawPath  string // Path as sent, the routes are matched on it so an encoded "/" isn't a separator
+	RawQuery string // query as sent by the client, without the "?"
 	Protocol string
-	headers  map[string]string
+	query    url.Values
+	headers  Header
+	trailers Header
+	body     *bodyReader
 	Body     []byte
+	h2       *http2Stream // stream of an HTTP/2 request
 }
 
 func NewRequest(request []byte) (*Request, error) {
-	lines := strings.Split(string(request), "\r\n")
-	if len(lines) < 1 {
-		return &Request{}, errors.New("invalid request")
+	var p parser
+	done, err := p.parse(request)
+	if err != nil {
+		return &Request{}, err
+	}
+	if !done {
+		return &Request{}, errors.New("incomplete request head")
+	}
+
+	req := &Request{}
+	if err := req.fromHead(string(request[:p.pos]), &p); err != nil {
+		return &Request{}, err
+	}
+
+	// Everything after the blank line is the body
+	if p.pos < len(request) {
+		req.Body = request[p.pos:]
+	}
+	return req, nil
+}
+
+// fromHead fills the request with the tokens found by the parser. Every string
+// points to the head, which is the single allocation made for the request.
+func (r *Request) fromHead(head string, p *parser) error {
+	r.Method = head[p.method.start:p.method.end]
+	r.Protocol = head[p.protocol.start:p.protocol.end]
+
+	target := head[p.target.start:p.target.end]
+	if target[0] != '/' && target != "*" && r.Method != MethodConnect {
+		target = originForm(target)
 	}
 
-	// Parse the first line (method, path, protocol)
-	headerFirstLine := strings.Split(lines[0], " ")
-	if len(headerFirstLine) < 3 {
-		return &Request{}, errors.New("invalid request line")
+	target, rawQuery, _ := strings.Cut(target, "?")
+	path, err := url.PathUnescape(target)
+	if err != nil {
+		return errInvalidTarget
 	}
+	r.Path, r.rawPath = path, target
+	r.RawQuery = rawQuery
 
-	req := &Request{
-		Method:   headerFirstLine[0],
-		Path:     headerFirstLine[1],
-		Protocol: headerFirstLine[2],
-		headers:  make(map[string]string),
+	for _, field := range p.fields {
+		r.headers.Add(head[field.key.start:field.key.end], head[field.value.start:field.value.end])
 	}
 
-	// Parse headers
-	for _, line := range lines[1:] {
-		if line == "" { // End of headers
-			break
+	return nil
+}
+
+// originForm returns the path and the query of an absolute-form target, "http://host/a?b" is "/a?b".
+func originForm(target string) string {
+	_, rest, _ := strings.Cut(target, "://")
+	i := strings.IndexAny(rest, "/?")
+	switch {
+	case i < 0:
+		return "/"
+	case rest[i] == '?':
+		return "/" + rest[i:]
+	}
+	return rest[i:]
+}
+
+// reset clears the request to be reused by the next request of the connection, keeping the allocated fields.
+func (r *Request) reset() {
+	*r = Request{
+		headers:  Header{fields: r.headers.fields[:0]},
+		trailers: Header{fields: r.trailers.fields[:0]},
+	}
+}
+
+// ContentLength returns the value of the Content-Length header,
+// 0 when it's absent or an error when it isn't a valid length.
+func (r *Request) ContentLength() (int64, error) {
+	value := r.GetHeader("Content-Length")
+	if value == "" {
+		return 0, nil
+	}
+
+	return parseContentLength(value)
+}
+
+// parseContentLength only accepts digits, strconv.ParseInt alone would accept a sign.
+func parseContentLength(value string) (int64, error) {
+	if value == "" || len(value) > 18 {
+		return 0, errInvalidContentLength
+	}
+
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, errInvalidContentLength
 		}
-		headerParts := strings.SplitN(line, ": ", 2)
-		if len(headerParts) == 2 {
-			req.headers[strings.ToLower(headerParts[0])] = headerParts[1]
+	}
+
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// validateFraming rejects the requests whose body length could be read differently by
+// another server in the path, which is how requests are smuggled (RFC 9112 section 6.3).
+func (r *Request) validateFraming() error {
+	// one pass counting the fields instead of collecting their values, it runs for every request
+	var hosts, lengths int
+	var first, length string
+	var lengthErr error
+	for _, field := range r.headers.fields {
+		switch {
+		case strings.EqualFold(field.key, "Host"):
+			hosts++
+
+		case strings.EqualFold(field.key, "Content-Length"):
+			if lengths == 0 {
+				first = field.value
+			}
+			lengths++
+
+			// repeated values are only accepted when they are all the same, as "5, 5"
+			for part := range strings.SplitSeq(field.value, ",") {
+				if lengthErr != nil {
+					break
+				}
+				part = strings.TrimSpace(part)
+				if _, err := parseContentLength(part); err != nil {
+					lengthErr = err
+				} else if length != "" && part != length {
+					lengthErr = errConflictingContentLength
+				}
+				length = part
+			}
 		}
 	}
 
-	// Set the body (last part of the request)
-	req.Body = []byte(lines[len(lines)-1])
-	return req, nil
+	if hosts > 1 || (hosts == 0 && r.Protocol == "HTTP/1.1") {
+		return errInvalidHost
+	}
+
+	if lengths == 0 {
+		return nil
+	}
+
+	if r.headers.Has("Transfer-Encoding") {
+		return errContentLengthWithTransferEncoding
+	}
+
+	if lengthErr != nil {
+		return lengthErr
+	}
+
+	if lengths > 1 || first != length {
+		r.headers.Set("Content-Length", length)
+	}
+
+	return nil
 }
 
-func (r *Request) GetHeader(key string) string {
-	value, ok := r.headers[strings.ToLower(key)]
-	if !ok {
-		return ""
+// chunked reports whether the body is sent with the chunked coding, the only transfer coding supported.
+func (r *Request) chunked() (bool, error) {
+	values := r.headers.Values("Transfer-Encoding")
+	if len(values) == 0 {
+		return false, nil
+	}
+
+	var codings []string
+	for _, value := range values {
+		for coding := range strings.SplitSeq(value, ",") {
+			codings = append(codings, strings.ToLower(strings.TrimSpace(coding)))
+		}
+	}
+
+	// without chunked as the last coding the length of the body can't be known
+	if codings[len(codings)-1] != "chunked" || slices.Index(codings, "chunked") != len(codings)-1 {
+		return false, errInvalidTransferEncoding
 	}
 
-	return value
+	if len(codings) > 1 {
+		return false, errUnsupportedTransferEncoding
+	}
+
+	return true, nil
+}
+
+// GetHeader returns the first value of a header, see Request.Headers for the repeated ones.
+func (r *Request) GetHeader(key string) string {
+	return r.headers.Get(key)
 }
 
 func (r *Request) SetHeader(key, val string) {
-	r.headers[strings.ToLower(key)] = val
+	r.headers.Set(key, val)
+}
+
+// Headers returns every header of the request, in the order they were sent.
+func (r *Request) Headers() *Header {
+	return &r.headers
+}
+
+// GetQuery returns every value of a query parameter, in the order they were sent.
+func (r *Request) GetQuery(key string) []string {
+	return r.Queries()[key]
+}
+
+// Queries returns every query parameter, it's parsed on the first call.
+func (r *Request) Queries() url.Values {
+	if r.query == nil {
+		// malformed pairs are skipped, the valid ones are still available
+		r.query, _ = url.ParseQuery(r.RawQuery)
+	}
+
+	return r.query
+}
+
+// GetTrailer returns a trailer field sent after a chunked body,
+// they are only available once the body was read.
+func (r *Request) GetTrailer(key string) string {
+	return r.trailers.Get(key)
 }
 
 type Response struct {
-	statusCode int
-	headers    map[string]string
-	body       []byte
+	statusCode   int
+	headers      *Header
+	trailers     *Header
+	body         []byte
+	preserveCase bool   // send the keys as they were set instead of canonical
+	protocol     string // protocol of the status line, HTTP/1.1 when empty
 }
 
-func NewResponse(statusCode int, headers map[string]string, body []byte) *Response {
+func NewResponse(statusCode int, headers *Header, body []byte) *Response {
 	if headers == nil {
-		headers = make(map[string]string)
+		headers = NewHeader()
 	}
 
 	r := &Response{
 		statusCode: statusCode,
 		headers:    headers,
+		trailers:   NewHeader(),
 	}
 	r.SetBody(body)
 	return r
 }
 
 func (r *Response) ToBytes() []byte {
-	statusLine := fmt.Sprintf("HTTP/1.1 %d %s\r\n", r.statusCode, StatusText[r.statusCode])
+	return append(r.headBytes(), r.body...)
+}
 
-	body := ""
-	if r.body != nil {
-		body = string(r.body)
+// headBytes returns the status line and the headers in the order they were set,
+// including the blank line that ends them.
+func (r *Response) headBytes() []byte {
+	protocol := r.protocol
+	if protocol == "" {
+		protocol = "HTTP/1.1"
 	}
 
-	headers := ""
-	if r.headers != nil {
-		for key, value := range r.headers {
-			headers += fmt.Sprintf("%s: %s\r\n", key, value)
-		}
-	}
+	head := make([]byte, 0, 256)
+	head = fmt.Appendf(head, "%s %d %s\r\n", protocol, r.statusCode, StatusText[r.statusCode])
+	head = r.headers.appendFields(head, r.preserveCase)
+	return append(head, "\r\n"...)
+}
 
-	return []byte(statusLine + headers + "\r\n" + body)
+// bodyless reports whether the status forbids a body, so not even an empty one is announced
+// with Content-Length (RFC 9110 section 8.6).
+func (r *Response) bodyless() bool {
+	return r.statusCode >= 100 && r.statusCode < 200 || r.statusCode == StatusNoContent
 }
 
 func (r *Response) GetBody() []byte {
@@ -115,7 +300,26 @@ func (r *Response) SetBodyString(body string) {
 }
 
 func (r *Response) SetHeader(key, value string) {
-	r.headers[strings.ToLower(key)] = value
+	r.headers.Set(key, value)
+}
+
+// AddHeader adds a value to the header, keeping the ones already set as for "Set-Cookie".
+func (r *Response) AddHeader(key, value string) {
+	r.headers.Add(key, value)
+}
+
+func (r *Response) DelHeader(key string) {
+	r.headers.Del(key)
+}
+
+// Headers returns every header of the response, in the order they were set.
+func (r *Response) Headers() *Header {
+	return r.headers
+}
+
+// SetTrailer sets a field sent after the body of a streamed response.
+func (r *Response) SetTrailer(key, value string) {
+	r.trailers.Set(key, value)
 }
 
 func (r *Response) LoadStatus() {
@@ -0,0 +1,821 @@
+package fast
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// http2Preface is sent by the client to start every HTTP/2 connection (RFC 9113 section 3.4).
+	http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	// http2InitialWindowSize is the size of the flow-control windows until the settings change it.
+	http2InitialWindowSize = 65535
+	// http2StreamWindowSize is the window granted to each request body, and http2ConnWindowSize
+	// to all of them, so it's the max memory used by the bodies not read yet.
+	http2StreamWindowSize = 1 << 20
+	http2ConnWindowSize   = 1 << 20
+
+	defaultMaxConcurrentStreams = 250
+)
+
+var (
+	// errHTTP2Preface is the start of a connection from a client with prior knowledge of HTTP/2,
+	// it's read as an HTTP/1 request line until the version.
+	errHTTP2Preface = errors.New("HTTP/2 connection preface")
+	// errStreamClosed is returned while writing the response of a stream reset by the client.
+	errStreamClosed = errors.New("the HTTP/2 stream was closed")
+	// errStreamEnded is returned while writing a response that was already sent.
+	errStreamEnded = errors.New("write on a finished HTTP/2 stream")
+)
+
+// negotiatedHTTP2 completes the TLS handshake of the connection and reports whether
+// the client chose HTTP/2 with ALPN. A failed handshake is left to the HTTP/1 reads.
+func (app *App) negotiatedHTTP2(c *connection) bool {
+	conn, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+
+	if err := c.SetDeadline(deadline(time.Now(), app.config.ReadHeaderTimeout)); err != nil {
+		return false
+	}
+
+	// waiting for the handshake, the connection can be closed by the shutdown
+	app.setIdle(c, true)
+	err := conn.Handshake()
+	app.setIdle(c, false)
+	if err != nil {
+		slog.Debug("failed the TLS handshake", "error", err)
+		return false
+	}
+
+	return conn.ConnectionState().NegotiatedProtocol == "h2"
+}
+
+// upgradeHTTP2 switches the connection to HTTP/2 when the request asks for it with "Upgrade: h2c"
+// (RFC 7540 section 3.2), the request is then answered on the stream 1. The upgrade is optional,
+// so the requests with a body and the ones over TLS, where h2 is negotiated with ALPN, are
+// answered with HTTP/1.1. It reports whether the connection was served with HTTP/2.
+func (app *App) upgradeHTTP2(c *connection, request *Request) bool {
+	if app.config.DisableHTTP2 || request.Protocol != "HTTP/1.1" || request.body != nil {
+		return false
+	}
+
+	if _, ok := c.Conn.(*tls.Conn); ok {
+		return false
+	}
+
+	if !request.headers.hasToken("Upgrade", "h2c") || !request.headers.hasToken("Connection", "HTTP2-Settings") {
+		return false
+	}
+
+	values := request.headers.Values("HTTP2-Settings")
+	if len(values) != 1 {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(values[0], "="))
+	if err != nil {
+		return false
+	}
+
+	settings, err := parseSettings(payload)
+	if err != nil {
+		return false
+	}
+
+	if _, err := io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n"); err != nil {
+		slog.Debug("failed to switch to HTTP/2", "error", err)
+		return true
+	}
+
+	request.Protocol = "HTTP/2.0"
+	request.headers.Del("Connection")
+	request.headers.Del("Upgrade")
+	request.headers.Del("HTTP2-Settings")
+
+	app.serveHTTP2(c, request, settings)
+	return true
+}
+
+// http2Conn is an HTTP/2 connection (RFC 9113). Its frames are read by the goroutine of the
+// connection while each stream is handled in its own goroutine, which writes the response.
+type http2Conn struct {
+	app     *App
+	conn    *connection
+	framer  *framer
+	decoder *hpackDecoder
+	fields  []headerField // fields of the last header block
+	// fieldsTooLarge is set when the fields of the last block were over MaxHeaderBytes, they're incomplete
+	fieldsTooLarge bool
+
+	// header block being received, until the CONTINUATION frame ending it
+	headerStream    uint32
+	headerEndStream bool
+	headerBlock     []byte
+
+	// wmu serializes the writes, the header blocks are encoded with it held
+	// since the client must decode them in the order they were encoded
+	wmu       sync.Mutex
+	encoder   *hpackEncoder
+	headerBuf []byte
+
+	// mu guards the state shared with the streams, it can be locked while holding wmu but not the opposite
+	mu            sync.Mutex
+	cond          *sync.Cond // broadcast when a window grows, data arrives or a stream ends
+	streams       map[uint32]*http2Stream
+	maxStreamID   uint32 // highest stream opened by the client
+	sendWindow    int64  // connection window of the client
+	recvWindow    int64  // connection window granted to the client
+	recvUnacked   int64  // data consumed and not granted again to the client yet
+	initialWindow int64  // SETTINGS_INITIAL_WINDOW_SIZE of the client
+	maxFrameSize  int    // SETTINGS_MAX_FRAME_SIZE of the client
+	goingAway     bool   // a GOAWAY was sent, the new streams are ignored
+	closed        bool
+
+	handlers  sync.WaitGroup
+	idleTimer *time.Timer
+}
+
+// serveHTTP2 serves the connection with HTTP/2 until it's closed. The upgrade is the request of
+// an "Upgrade: h2c", answered on the stream 1 with the settings of its HTTP2-Settings header.
+func (app *App) serveHTTP2(c *connection, upgrade *Request, settings []setting) {
+	// the connection is read while the streams write, so the reads can't flush the writer as for HTTP/1
+	c.reader.rd = c.Conn
+
+	sc := &http2Conn{
+		app:           app,
+		conn:          c,
+		framer:        newFramer(c.reader, c.writer),
+		decoder:       newHPACKDecoder(defaultHeaderTableSize),
+		encoder:       newHPACKEncoder(),
+		streams:       make(map[uint32]*http2Stream),
+		sendWindow:    http2InitialWindowSize,
+		recvWindow:    http2ConnWindowSize,
+		initialWindow: http2InitialWindowSize,
+		maxFrameSize:  minMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+	defer sc.close()
+
+	err := sc.serve(upgrade, settings)
+
+	var connErr connectionError
+	if errors.As(err, &connErr) {
+		sc.goAway(connErr.code, connErr.reason)
+		sc.linger()
+	}
+	slog.Debug("closing the HTTP/2 connection", "error", err)
+}
+
+func (sc *http2Conn) serve(upgrade *Request, settings []setting) error {
+	config := &sc.app.config
+
+	err := sc.write(func() error {
+		err := sc.framer.writeSettings(
+			setting{settingMaxConcurrentStreams, uint32(config.MaxConcurrentStreams)},
+			setting{settingInitialWindowSize, http2StreamWindowSize},
+			setting{settingMaxHeaderListSize, uint32(config.MaxHeaderBytes)},
+		)
+		if err != nil {
+			return err
+		}
+
+		// the connection window only grows with WINDOW_UPDATE
+		return sc.framer.writeWindowUpdate(0, http2ConnWindowSize-http2InitialWindowSize)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the preface must arrive as the head of a request, then the idle timer takes over
+	if err := sc.conn.SetReadDeadline(deadline(time.Now(), config.ReadHeaderTimeout)); err != nil {
+		return err
+	}
+
+	preface := make([]byte, len(http2Preface))
+	if _, err := io.ReadFull(sc.conn.reader, preface); err != nil {
+		return err
+	}
+	if string(preface) != http2Preface {
+		return connectionError{errCodeProtocol, "invalid connection preface"}
+	}
+
+	f, err := sc.framer.readFrame()
+	if err != nil {
+		return err
+	}
+	if f.typ != frameSettings || f.has(flagAck) {
+		return connectionError{errCodeProtocol, "the connection preface doesn't end with SETTINGS"}
+	}
+	if err := sc.processFrame(f); err != nil {
+		return err
+	}
+
+	if err := sc.conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	sc.idleTimer = time.AfterFunc(config.IdleTimeout, sc.closeIdle)
+	if upgrade != nil {
+		sc.wmu.Lock()
+		err := sc.applySettings(settings)
+		sc.wmu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		st := sc.newStream(1, true)
+		st.request = *upgrade
+		st.request.h2 = st
+
+		sc.mu.Lock()
+		sc.maxStreamID = 1
+		sc.mu.Unlock()
+		sc.startStream(st, nil)
+	} else {
+		sc.app.setIdle(sc.conn, true)
+	}
+
+	for {
+		f, err := sc.framer.readFrame()
+		if err != nil {
+			return err
+		}
+
+		if err := sc.processFrame(f); err != nil {
+			var streamErr streamError
+			if !errors.As(err, &streamErr) {
+				return err
+			}
+
+			slog.Debug("resetting the HTTP/2 stream", "error", err)
+			if err := sc.resetStream(streamErr.streamID, streamErr.code); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// close stops the streams once the connection can't be read anymore and waits for their handlers.
+func (sc *http2Conn) close() {
+	sc.mu.Lock()
+	sc.closed = true
+	for _, st := range sc.streams {
+		if st.bodyErr == nil {
+			st.bodyErr = errStreamClosed
+		}
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+
+	// the handlers can't block writing to a client that doesn't read anymore
+	sc.conn.Conn.Close()
+	sc.handlers.Wait()
+
+	// the idle timer could still be writing
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+}
+
+// write writes frames and sends them.
+func (sc *http2Conn) write(frames func() error) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	return sc.flush(frames)
+}
+
+// flush writes frames and sends them while holding wmu. The connection is closed when it fails,
+// so the frames of the other streams aren't sent after a partial frame.
+func (sc *http2Conn) flush(frames func() error) error {
+	if timeout := sc.app.config.WriteTimeout; timeout > 0 {
+		if err := sc.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			sc.conn.Conn.Close()
+			return err
+		}
+	}
+
+	err := frames()
+	if err == nil {
+		err = sc.conn.Flush()
+	}
+	if err != nil {
+		sc.conn.Conn.Close()
+	}
+
+	return err
+}
+
+// goAway tells the client the last stream that is handled, it must open a new connection for the next ones.
+func (sc *http2Conn) goAway(code http2ErrCode, reason string) {
+	sc.mu.Lock()
+	if sc.closed || (sc.goingAway && code == errCodeNo) {
+		sc.mu.Unlock()
+		return
+	}
+	sc.goingAway = true
+	lastStreamID := sc.maxStreamID
+	sc.mu.Unlock()
+
+	if err := sc.write(func() error { return sc.framer.writeGoAway(lastStreamID, code, reason) }); err != nil {
+		slog.Debug("failed to send GOAWAY", "error", err)
+	}
+}
+
+// linger discards what the client still sends for a moment after a GOAWAY, since closing the
+// connection with unread data resets it and the client could lose the GOAWAY frame.
+func (sc *http2Conn) linger() {
+	if conn, ok := sc.conn.Conn.(interface{ CloseWrite() error }); ok {
+		if err := conn.CloseWrite(); err != nil {
+			return
+		}
+	}
+
+	if err := sc.conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		return
+	}
+	io.Copy(io.Discard, sc.conn.reader)
+}
+
+// closeIdle closes the connection once it had no stream for Config.IdleTimeout.
+func (sc *http2Conn) closeIdle() {
+	sc.mu.Lock()
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+	if !idle {
+		return
+	}
+
+	slog.Debug("the idle timeout of the HTTP/2 connection was reached, closing it")
+	sc.goAway(errCodeNo, "")
+	sc.conn.Conn.Close()
+}
+
+// broadcast wakes up the streams waiting, as when their deadline passes.
+func (sc *http2Conn) broadcast() {
+	sc.mu.Lock()
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+}
+
+func (sc *http2Conn) processFrame(f frame) error {
+	// nothing can interrupt a header block (RFC 9113 section 4.3)
+	if sc.headerStream != 0 && (f.typ != frameContinuation || f.streamID != sc.headerStream) {
+		return connectionError{errCodeProtocol, "expected a CONTINUATION frame"}
+	}
+
+	switch f.typ {
+	case frameData:
+		return sc.processData(f)
+	case frameHeaders:
+		return sc.processHeaders(f)
+	case frameContinuation:
+		return sc.processContinuation(f)
+	case framePriority:
+		if f.streamID == 0 {
+			return connectionError{errCodeProtocol, "PRIORITY frame on the stream 0"}
+		}
+		if len(f.payload) != 5 {
+			return streamError{f.streamID, errCodeFrameSize, "invalid PRIORITY length"}
+		}
+		// the priorities are deprecated, the streams are served as they come
+		return nil
+	case frameRSTStream:
+		return sc.processRSTStream(f)
+	case frameSettings:
+		return sc.processSettings(f)
+	case framePushPromise:
+		return connectionError{errCodeProtocol, "PUSH_PROMISE frame sent by the client"}
+	case framePing:
+		return sc.processPing(f)
+	case frameGoAway:
+		if f.streamID != 0 {
+			return connectionError{errCodeProtocol, "GOAWAY frame on a stream"}
+		}
+		// the client doesn't open new streams, it closes the connection once it's done
+		return nil
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(f)
+	default:
+		// the unknown frame types are ignored (RFC 9113 section 5.5)
+		return nil
+	}
+}
+
+// unpad removes the padding of a DATA or HEADERS frame.
+func unpad(f frame) ([]byte, error) {
+	if !f.has(flagPadded) {
+		return f.payload, nil
+	}
+
+	if len(f.payload) == 0 || int(f.payload[0]) >= len(f.payload) {
+		return nil, connectionError{errCodeProtocol, "padding longer than the frame"}
+	}
+	return f.payload[1 : len(f.payload)-int(f.payload[0])], nil
+}
+
+func (sc *http2Conn) processHeaders(f frame) error {
+	if f.streamID == 0 {
+		return connectionError{errCodeProtocol, "HEADERS frame on the stream 0"}
+	}
+
+	block, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	if f.has(flagPriority) {
+		if len(block) < 5 {
+			return connectionError{errCodeFrameSize, "HEADERS frame too short for its priority"}
+		}
+		block = block[5:]
+	}
+
+	sc.headerStream = f.streamID
+	sc.headerEndStream = f.has(flagEndStream)
+	sc.headerBlock = append(sc.headerBlock[:0], block...)
+	return sc.continueHeaders(f.has(flagEndHeaders))
+}
+
+func (sc *http2Conn) processContinuation(f frame) error {
+	if sc.headerStream == 0 {
+		return connectionError{errCodeProtocol, "CONTINUATION frame without HEADERS"}
+	}
+
+	sc.headerBlock = append(sc.headerBlock, f.payload...)
+	return sc.continueHeaders(f.has(flagEndHeaders))
+}
+
+func (sc *http2Conn) continueHeaders(end bool) error {
+	// a block can't be skipped without decoding it, the dynamic tables would differ after it
+	if len(sc.headerBlock) > sc.app.config.MaxHeaderBytes {
+		return connectionError{errCodeCompression, "header block larger than MaxHeaderBytes"}
+	}
+
+	if !end {
+		return nil
+	}
+
+	id := sc.headerStream
+	sc.headerStream = 0
+
+	// every block is decoded, even the ones of the streams refused, to keep the dynamic table, but
+	// the fields past the SETTINGS_MAX_HEADER_LIST_SIZE announced aren't collected: a small block
+	// can repeat the index of a large field many times
+	sc.fields = sc.fields[:0]
+	size := 0
+	err := sc.decoder.decode(sc.headerBlock, func(key, value string) {
+		size += entrySize(key, value)
+		if size <= sc.app.config.MaxHeaderBytes {
+			sc.fields = append(sc.fields, headerField{key: key, value: value})
+		}
+	})
+	if err != nil {
+		return connectionError{errCodeCompression, err.Error()}
+	}
+	sc.fieldsTooLarge = size > sc.app.config.MaxHeaderBytes
+
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+	if st != nil {
+		return sc.processTrailers(st)
+	}
+
+	return sc.openStream(id)
+}
+
+// openStream starts handling the request of a new stream.
+func (sc *http2Conn) openStream(id uint32) error {
+	if id%2 == 0 {
+		return connectionError{errCodeProtocol, "stream opened by the client with an even identifier"}
+	}
+
+	sc.mu.Lock()
+	if id <= sc.maxStreamID {
+		// a stream already closed, as one reset by the server
+		sc.mu.Unlock()
+		return nil
+	}
+	goingAway, streams := sc.goingAway, len(sc.streams)
+	sc.mu.Unlock()
+
+	// the streams after the GOAWAY are ignored, the client opens them again on a new connection
+	if sc.app.shuttingDown.Load() {
+		sc.goAway(errCodeNo, "")
+		goingAway = true
+	}
+
+	sc.mu.Lock()
+	sc.maxStreamID = id
+	sc.mu.Unlock()
+
+	if goingAway {
+		return nil
+	}
+
+	if streams >= sc.app.config.MaxConcurrentStreams {
+		return streamError{id, errCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	st := sc.newStream(id, sc.headerEndStream)
+	err := error(errHeaderTooLarge)
+	if !sc.fieldsTooLarge {
+		err = st.readHeaders(sc.fields)
+	}
+
+	var streamErr streamError
+	if errors.As(err, &streamErr) {
+		return streamErr
+	}
+	sc.startStream(st, err)
+
+	// as for HTTP/1, the connection is closed once the last request is answered
+	sc.conn.requests++
+	if maxRequests := sc.app.config.MaxRequestsPerConn; maxRequests > 0 && sc.conn.requests >= maxRequests {
+		sc.goAway(errCodeNo, "")
+	}
+
+	return nil
+}
+
+func (sc *http2Conn) processTrailers(st *http2Stream) error {
+	if !sc.headerEndStream {
+		return streamError{st.id, errCodeProtocol, "trailers without END_STREAM"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if st.reset {
+		return nil
+	}
+	if st.remoteEnded {
+		return streamError{st.id, errCodeStreamClosed, "HEADERS frame after END_STREAM"}
+	}
+
+	if sc.fieldsTooLarge {
+		return streamError{st.id, errCodeProtocol, "trailers larger than MaxHeaderBytes"}
+	}
+
+	for _, field := range sc.fields {
+		if !validHTTP2FieldName(field.key) || field.key[0] == ':' || !validFieldValue(field.value) {
+			return streamError{st.id, errCodeProtocol, "invalid trailer field"}
+		}
+		st.trailers = append(st.trailers, field)
+	}
+
+	return st.endRemote()
+}
+
+func (sc *http2Conn) processData(f frame) error {
+	if f.streamID == 0 {
+		return connectionError{errCodeProtocol, "DATA frame on the stream 0"}
+	}
+
+	data, err := unpad(f)
+	if err != nil {
+		return err
+	}
+
+	// the padding counts for the flow control, it's given back right away
+	length := int64(len(f.payload))
+	padding := length - int64(len(data))
+
+	var connIncrement uint32
+	defer func() {
+		if connIncrement > 0 {
+			// a failure closes the connection, so it ends the reads as well
+			_ = sc.write(func() error { return sc.framer.writeWindowUpdate(0, connIncrement) })
+		}
+	}()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	// without it, the data buffered for the streams not read could reach MaxConcurrentStreams windows
+	if length > sc.recvWindow {
+		return connectionError{errCodeFlowControl, "DATA frame over the connection window"}
+	}
+	sc.recvWindow -= length
+
+	sc.recvUnacked += padding
+	st := sc.streams[f.streamID]
+	if st == nil || st.reset || st.remoteEnded {
+		// the data of the closed streams still counts for the connection window
+		sc.recvUnacked += int64(len(data))
+		connIncrement = sc.connWindowUpdate()
+
+		switch {
+		case f.streamID > sc.maxStreamID:
+			return connectionError{errCodeProtocol, "DATA frame on an idle stream"}
+		case st != nil && st.remoteEnded && !st.reset:
+			return streamError{f.streamID, errCodeStreamClosed, "DATA frame after END_STREAM"}
+		}
+		return nil
+	}
+
+	if length > st.recvWindow {
+		sc.recvUnacked += int64(len(data))
+		connIncrement = sc.connWindowUpdate()
+		return streamError{st.id, errCodeFlowControl, "DATA frame over the stream window"}
+	}
+	st.recvWindow -= length
+	st.recvUnacked += padding
+
+	st.received += int64(len(data))
+	if st.length >= 0 && st.received > st.length {
+		return streamError{st.id, errCodeProtocol, "body longer than Content-Length"}
+	}
+	st.body.Write(data)
+	sc.cond.Broadcast()
+
+	connIncrement = sc.connWindowUpdate()
+	if f.has(flagEndStream) {
+		return st.endRemote()
+	}
+
+	return nil
+}
+
+// connWindowUpdate returns the increment of the connection window to send, once
+// half of the window was consumed. It must be called holding the lock.
+func (sc *http2Conn) connWindowUpdate() uint32 {
+	if sc.recvUnacked < http2ConnWindowSize/2 {
+		return 0
+	}
+
+	increment := uint32(sc.recvUnacked)
+	sc.recvWindow += sc.recvUnacked
+	sc.recvUnacked = 0
+	return increment
+}
+
+func (sc *http2Conn) processRSTStream(f frame) error {
+	if f.streamID == 0 {
+		return connectionError{errCodeProtocol, "RST_STREAM frame on the stream 0"}
+	}
+	if len(f.payload) != 4 {
+		return connectionError{errCodeFrameSize, "invalid RST_STREAM length"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	st := sc.streams[f.streamID]
+	if st == nil {
+		if f.streamID > sc.maxStreamID {
+			return connectionError{errCodeProtocol, "RST_STREAM frame on an idle stream"}
+		}
+		return nil
+	}
+
+	st.reset = true
+	if st.bodyErr == nil {
+		st.bodyErr = errStreamClosed
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *http2Conn) processSettings(f frame) error {
+	if f.streamID != 0 {
+		return connectionError{errCodeProtocol, "SETTINGS frame on a stream"}
+	}
+
+	if f.has(flagAck) {
+		if len(f.payload) != 0 {
+			return connectionError{errCodeFrameSize, "SETTINGS acknowledgment with a payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(f.payload)
+	if err != nil {
+		return err
+	}
+
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	// acknowledged once applied, so the next header blocks follow the new table size
+	return sc.flush(sc.framer.writeSettingsAck)
+}
+
+// applySettings applies the settings of the client, it must be called holding wmu.
+func (sc *http2Conn) applySettings(settings []setting) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for _, s := range settings {
+		switch s.id {
+		case settingHeaderTableSize:
+			sc.encoder.setMaxTableSize(int(s.value))
+
+		case settingInitialWindowSize:
+			// the windows of the open streams change by the difference (RFC 9113 section 6.9.2)
+			delta := int64(s.value) - sc.initialWindow
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					return connectionError{errCodeFlowControl, "stream window over the max size"}
+				}
+			}
+			sc.initialWindow = int64(s.value)
+
+		case settingMaxFrameSize:
+			sc.maxFrameSize = int(s.value)
+		}
+	}
+
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *http2Conn) processPing(f frame) error {
+	if f.streamID != 0 {
+		return connectionError{errCodeProtocol, "PING frame on a stream"}
+	}
+	if len(f.payload) != 8 {
+		return connectionError{errCodeFrameSize, "invalid PING length"}
+	}
+
+	if f.has(flagAck) {
+		return nil
+	}
+	return sc.write(func() error { return sc.framer.writePing(true, f.payload) })
+}
+
+func (sc *http2Conn) processWindowUpdate(f frame) error {
+	if len(f.payload) != 4 {
+		return connectionError{errCodeFrameSize, "invalid WINDOW_UPDATE length"}
+	}
+	increment := int64(binary.BigEndian.Uint32(f.payload) & maxWindowSize)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if f.streamID == 0 {
+		if increment == 0 {
+			return connectionError{errCodeProtocol, "WINDOW_UPDATE without increment"}
+		}
+
+		sc.sendWindow += increment
+		if sc.sendWindow > maxWindowSize {
+			return connectionError{errCodeFlowControl, "connection window over the max size"}
+		}
+		sc.cond.Broadcast()
+		return nil
+	}
+
+	st := sc.streams[f.streamID]
+	if st == nil {
+		if f.streamID > sc.maxStreamID {
+			return connectionError{errCodeProtocol, "WINDOW_UPDATE frame on an idle stream"}
+		}
+		return nil
+	}
+
+	if increment == 0 {
+		return streamError{st.id, errCodeProtocol, "WINDOW_UPDATE without increment"}
+	}
+
+	st.sendWindow += increment
+	if st.sendWindow > maxWindowSize {
+		return streamError{st.id, errCodeFlowControl, "stream window over the max size"}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+// resetStream ends a stream with RST_STREAM, its handler can't write anymore.
+func (sc *http2Conn) resetStream(id uint32, code http2ErrCode) error {
+	sc.mu.Lock()
+	if st := sc.streams[id]; st != nil {
+		st.reset = true
+		if st.bodyErr == nil {
+			st.bodyErr = errStreamClosed
+		}
+		sc.cond.Broadcast()
+	}
+	sc.mu.Unlock()
+
+	return sc.write(func() error { return sc.framer.writeRSTStream(id, code) })
+}
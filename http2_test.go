@@ -0,0 +1,347 @@
+package fast
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// http2TestClient speaks raw HTTP/2 frames to an app served on the loopback.
+type http2TestClient struct {
+	t       *testing.T
+	conn    net.Conn
+	reader  *bufio.Reader
+	framer  *framer
+	encoder *hpackEncoder
+	decoder *hpackDecoder
+}
+
+func newHTTP2TestClient(t *testing.T, app *App) *http2TestClient {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		if err := app.Listener(ln); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+	t.Cleanup(func() {
+		if err := app.Shutdown(true); err != nil {
+			t.Errorf("failed to shutdown: %v", err)
+		}
+	})
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	reader := bufio.NewReader(conn)
+	return &http2TestClient{
+		t:       t,
+		conn:    conn,
+		reader:  reader,
+		framer:  newFramer(reader, conn),
+		encoder: newHPACKEncoder(),
+		decoder: newHPACKDecoder(defaultHeaderTableSize),
+	}
+}
+
+// preface starts the connection with prior knowledge.
+func (c *http2TestClient) preface(settings ...setting) {
+	_, err := io.WriteString(c.conn, http2Preface)
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.framer.writeSettings(settings...))
+}
+
+func (c *http2TestClient) headers(streamID uint32, endStream bool, fields ...string) {
+	var block []byte
+	for i := 0; i < len(fields); i += 2 {
+		block = c.encoder.appendField(block, fields[i], fields[i+1])
+	}
+	require.NoError(c.t, c.framer.writeHeaders(streamID, endStream, block, minMaxFrameSize))
+}
+
+func (c *http2TestClient) get(streamID uint32, path string) {
+	c.headers(streamID, true, ":method", "GET", ":scheme", "http", ":authority", "localhost", ":path", path)
+}
+
+// next reads the frames until one of the type, answering the SETTINGS of the server.
+func (c *http2TestClient) next(typ frameType) frame {
+	for {
+		f, err := c.framer.readFrame()
+		require.NoError(c.t, err)
+
+		if f.typ == typ {
+			f.payload = append([]byte(nil), f.payload...)
+			return f
+		}
+		if f.typ == frameSettings && !f.has(flagAck) {
+			// the server could already have closed the connection
+			c.framer.writeSettingsAck()
+		}
+	}
+}
+
+func (c *http2TestClient) fields(f frame) map[string]string {
+	require.True(c.t, f.has(flagEndHeaders))
+
+	fields := make(map[string]string)
+	require.NoError(c.t, c.decoder.decode(f.payload, func(key, value string) {
+		fields[key] = value
+	}))
+	return fields
+}
+
+// errCode returns the code of a RST_STREAM or GOAWAY frame.
+func errCode(f frame) http2ErrCode {
+	if f.typ == frameGoAway {
+		// after the last stream identifier
+		return http2ErrCode(binary.BigEndian.Uint32(f.payload[4:]))
+	}
+	return http2ErrCode(binary.BigEndian.Uint32(f.payload))
+}
+
+func newHTTP2TestApp() *App {
+	app := New(Config{MaxConcurrentStreams: 1})
+	app.Get("/hello", func(c *Ctx) error {
+		return c.SendString("hello " + c.Request.Protocol)
+	})
+	return app
+}
+
+func TestHTTP2Upgrade(t *testing.T) {
+	c := newHTTP2TestClient(t, newHTTP2TestApp())
+
+	// SETTINGS_MAX_CONCURRENT_STREAMS of 100
+	_, err := io.WriteString(c.conn, "GET /hello HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\n"+
+		"Upgrade: h2c\r\nHTTP2-Settings: AAMAAABk\r\n\r\n")
+	require.NoError(t, err)
+
+	status, err := c.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for line := status; line != "\r\n"; {
+		line, err = c.reader.ReadString('\n')
+		require.NoError(t, err)
+	}
+
+	c.preface()
+
+	headers := c.next(frameHeaders)
+	assert.Equal(t, uint32(1), headers.streamID)
+	assert.Equal(t, "200", c.fields(headers)[":status"])
+
+	data := c.next(frameData)
+	assert.Equal(t, "hello HTTP/2.0", string(data.payload))
+	assert.True(t, data.has(flagEndStream))
+
+	// the connection goes on with the streams of the client
+	c.get(3, "/hello")
+	assert.Equal(t, uint32(3), c.next(frameHeaders).streamID)
+}
+
+func TestHTTP2FlowControl(t *testing.T) {
+	t.Run("should wait for the window of the client", func(t *testing.T) {
+		c := newHTTP2TestClient(t, newHTTP2TestApp())
+		c.preface(setting{settingInitialWindowSize, 10})
+		c.get(1, "/hello")
+
+		assert.Equal(t, "200", c.fields(c.next(frameHeaders))[":status"])
+
+		data := c.next(frameData)
+		assert.Equal(t, "hello HTTP", string(data.payload))
+		assert.False(t, data.has(flagEndStream))
+
+		require.NoError(t, c.framer.writeWindowUpdate(1, 10))
+		data = c.next(frameData)
+		assert.Equal(t, "/2.0", string(data.payload))
+		assert.True(t, data.has(flagEndStream))
+	})
+
+	t.Run("should fail the data over the connection window", func(t *testing.T) {
+		app := New(Config{})
+		release := make(chan struct{})
+		defer close(release)
+		app.Post("/wait", func(c *Ctx) error {
+			<-release
+			return nil
+		})
+
+		c := newHTTP2TestClient(t, app)
+		c.preface()
+
+		// each stream stays in its window, not both in the one of the connection
+		chunk := make([]byte, minMaxFrameSize)
+		for _, id := range []uint32{1, 3} {
+			c.headers(id, false, ":method", "POST", ":scheme", "http", ":path", "/wait")
+			for range http2StreamWindowSize * 3 / 4 / len(chunk) {
+				require.NoError(t, c.framer.writeData(id, false, chunk))
+			}
+		}
+
+		assert.Equal(t, errCodeFlowControl, errCode(c.next(frameGoAway)))
+	})
+}
+
+func TestHTTP2Streams(t *testing.T) {
+	t.Run("should refuse the streams over MaxConcurrentStreams", func(t *testing.T) {
+		app := newHTTP2TestApp()
+		release := make(chan struct{})
+		app.Get("/wait", func(c *Ctx) error {
+			<-release
+			return c.SendString("released")
+		})
+
+		c := newHTTP2TestClient(t, app)
+		c.preface()
+		c.get(1, "/wait")
+		c.get(3, "/hello")
+
+		reset := c.next(frameRSTStream)
+		assert.Equal(t, uint32(3), reset.streamID)
+		assert.Equal(t, errCodeRefusedStream, errCode(reset))
+
+		close(release)
+		headers := c.next(frameHeaders)
+		assert.Equal(t, uint32(1), headers.streamID)
+		assert.Equal(t, "200", c.fields(headers)[":status"])
+	})
+
+	t.Run("should reset the malformed requests", func(t *testing.T) {
+		requests := map[string][]string{
+			"missing :path":          {":method", "GET", ":scheme", "http"},
+			"upper case field":       {":method", "GET", ":scheme", "http", ":path", "/", "X-Name", "value"},
+			"pseudo-header last":     {":method", "GET", ":scheme", "http", "x-name", "value", ":path", "/"},
+			"connection header":      {":method", "GET", ":scheme", "http", ":path", "/", "connection", "keep-alive"},
+			"te other than trailers": {":method", "GET", ":scheme", "http", ":path", "/", "te", "gzip"},
+			"missing body":           {":method", "POST", ":scheme", "http", ":path", "/", "content-length", "5"},
+		}
+
+		c := newHTTP2TestClient(t, newHTTP2TestApp())
+		c.preface()
+
+		id := uint32(1)
+		for name, fields := range requests {
+			c.headers(id, true, fields...)
+
+			reset := c.next(frameRSTStream)
+			assert.Equal(t, id, reset.streamID, name)
+			assert.Equal(t, errCodeProtocol, errCode(reset), name)
+			id += 2
+		}
+
+		// the connection is still usable
+		c.get(id, "/hello")
+		assert.Equal(t, "200", c.fields(c.next(frameHeaders))[":status"])
+	})
+
+	t.Run("should answer 431 when the decoded fields are over MaxHeaderBytes", func(t *testing.T) {
+		c := newHTTP2TestClient(t, newHTTP2TestApp())
+		c.preface()
+
+		// a field of 4 KB, then its index of the dynamic table repeated for 4 MB of fields
+		large := strings.Repeat("a", 4000)
+		var block []byte
+		for _, field := range [][2]string{{":method", "GET"}, {":scheme", "http"}, {":path", "/hello"}, {"x-large", large}} {
+			block = c.encoder.appendField(block, field[0], field[1])
+		}
+		block = append(block, bytes.Repeat([]byte{0xbe}, 1000)...)
+		require.NoError(t, c.framer.writeHeaders(1, true, block, minMaxFrameSize))
+
+		headers := c.next(frameHeaders)
+		assert.Equal(t, uint32(1), headers.streamID)
+		assert.Equal(t, "431", c.fields(headers)[":status"])
+
+		// the dynamic table is still shared with the client
+		c.headers(3, true, ":method", "GET", ":scheme", "http", ":path", "/hello", "x-large", large)
+		headers = c.next(frameHeaders)
+		assert.Equal(t, uint32(3), headers.streamID)
+		assert.Equal(t, "200", c.fields(headers)[":status"])
+	})
+
+	t.Run("should answer the pings", func(t *testing.T) {
+		c := newHTTP2TestClient(t, newHTTP2TestApp())
+		c.preface()
+		require.NoError(t, c.framer.writePing(false, []byte("12345678")))
+
+		ping := c.next(framePing)
+		assert.True(t, ping.has(flagAck))
+		assert.Equal(t, "12345678", string(ping.payload))
+	})
+}
+
+func TestHTTP2ConnectionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *http2TestClient) error
+		code http2ErrCode
+	}{
+		{
+			name: "stream opened with an even identifier",
+			send: func(c *http2TestClient) error { c.get(2, "/hello"); return nil },
+			code: errCodeProtocol,
+		},
+		{
+			name: "SETTINGS length not a multiple of 6",
+			send: func(c *http2TestClient) error { return c.framer.writeFrame(frameSettings, 0, 0, make([]byte, 5)) },
+			code: errCodeFrameSize,
+		},
+		{
+			name: "WINDOW_UPDATE without increment",
+			send: func(c *http2TestClient) error { return c.framer.writeWindowUpdate(0, 0) },
+			code: errCodeProtocol,
+		},
+		{
+			name: "frame larger than SETTINGS_MAX_FRAME_SIZE",
+			send: func(c *http2TestClient) error {
+				return c.framer.writeFrame(frameData, 0, 1, make([]byte, minMaxFrameSize+1))
+			},
+			code: errCodeFrameSize,
+		},
+		{
+			name: "CONTINUATION without HEADERS",
+			send: func(c *http2TestClient) error { return c.framer.writeFrame(frameContinuation, flagEndHeaders, 1, nil) },
+			code: errCodeProtocol,
+		},
+		{
+			name: "invalid header block",
+			send: func(c *http2TestClient) error {
+				return c.framer.writeFrame(frameHeaders, flagEndHeaders|flagEndStream, 1, []byte{0x80})
+			},
+			code: errCodeCompression,
+		},
+		{
+			name: "PING of 7 bytes",
+			send: func(c *http2TestClient) error { return c.framer.writeFrame(framePing, 0, 0, make([]byte, 7)) },
+			code: errCodeFrameSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newHTTP2TestClient(t, newHTTP2TestApp())
+			c.preface()
+			require.NoError(t, tt.send(c))
+
+			assert.Equal(t, tt.code, errCode(c.next(frameGoAway)))
+
+			// the connection is closed after the GOAWAY
+			for {
+				if _, err := c.framer.readFrame(); err != nil {
+					assert.ErrorIs(t, err, io.EOF)
+					break
+				}
+			}
+		})
+	}
+}
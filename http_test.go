@@ -21,4 +21,171 @@ func TestRequest(t *testing.T) {
 		assert.Equal(t, createdRequest.GetHeader("Accept"), "*/*")
 		assert.Equal(t, string(createdRequest.Body), "foobar")
 	})
+
+	t.Run("should route the absolute-form targets on their path", func(t *testing.T) {
+		tests := map[string][2]string{
+			"http://example.com/users/a%2Fb?id=1": {"/users/a/b", "id=1"},
+			"https://example.com?id=1":            {"/", "id=1"},
+			"http://example.com":                  {"/", ""},
+		}
+
+		for target, expected := range tests {
+			createdRequest, err := NewRequest([]byte("GET " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+			require.NoError(t, err, target)
+
+			assert.Equal(t, expected[0], createdRequest.Path, target)
+			assert.Equal(t, expected[1], createdRequest.RawQuery, target)
+		}
+	})
+}
+
+func TestRequestBody(t *testing.T) {
+	t.Run("should keep CRLF sequences inside the body", func(t *testing.T) {
+		request := []byte("POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 12\r\n\r\nfoo\r\nbar\r\n\r\n")
+		createdRequest, err := NewRequest(request)
+		require.NoError(t, err)
+
+		assert.Equal(t, "foo\r\nbar\r\n\r\n", string(createdRequest.Body))
+		length, err := createdRequest.ContentLength()
+		require.NoError(t, err)
+		assert.Equal(t, int64(12), length)
+	})
+
+	t.Run("should reject an invalid content length", func(t *testing.T) {
+		createdRequest, err := NewRequest([]byte("POST / HTTP/1.1\r\nContent-Length: -1\r\n\r\n"))
+		require.NoError(t, err)
+
+		_, err = createdRequest.ContentLength()
+		assert.Error(t, err)
+	})
+}
+
+func TestRequestQuery(t *testing.T) {
+	t.Run("should split and decode the path and the query", func(t *testing.T) {
+		createdRequest, err := NewRequest([]byte("GET /users/john%20doe?id=1&tag=a&tag=b%20c HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "/users/john doe", createdRequest.Path)
+		assert.Equal(t, "id=1&tag=a&tag=b%20c", createdRequest.RawQuery)
+		assert.Equal(t, []string{"1"}, createdRequest.GetQuery("id"))
+		assert.Equal(t, []string{"a", "b c"}, createdRequest.GetQuery("tag"))
+	})
+
+	t.Run("should reject an invalid percent-encoding in the path", func(t *testing.T) {
+		_, err := NewRequest([]byte("GET /users/%zz HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestRequestHeaders(t *testing.T) {
+	t.Run("should keep the repeated headers", func(t *testing.T) {
+		createdRequest, err := NewRequest([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nX-Forwarded-For: 10.0.0.1\r\nAccept: text/html\r\nX-Forwarded-For: 10.0.0.2\r\n\r\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "10.0.0.1", createdRequest.GetHeader("X-Forwarded-For"))
+		assert.Equal(t, []string{"10.0.0.1", "10.0.0.2"}, createdRequest.Headers().Values("X-Forwarded-For"))
+	})
+}
+
+func TestResponse(t *testing.T) {
+	t.Run("should serialize the headers canonical and in insertion order", func(t *testing.T) {
+		response := NewResponse(StatusOK, nil, nil)
+		response.SetHeader("x-request-id", "42")
+		response.AddHeader("set-cookie", "a=1")
+		response.SetHeader("CONTENT-TYPE", "text/plain")
+		response.AddHeader("Set-Cookie", "b=2")
+		response.SetBody([]byte("OK"))
+
+		expected := "HTTP/1.1 200 OK\r\n" +
+			"Content-Length: 2\r\n" +
+			"X-Request-Id: 42\r\n" +
+			"Set-Cookie: a=1\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Set-Cookie: b=2\r\n" +
+			"\r\n" +
+			"OK"
+		assert.Equal(t, expected, string(response.ToBytes()))
+	})
+
+	t.Run("should keep the case used when preserving it", func(t *testing.T) {
+		response := NewResponse(StatusOK, nil, nil)
+		response.preserveCase = true
+		response.SetHeader("x-LEGACY-header", "1")
+
+		assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nx-LEGACY-header: 1\r\n\r\n", string(response.ToBytes()))
+	})
+
+	t.Run("should use the protocol of the request in the status line", func(t *testing.T) {
+		response := NewResponse(StatusNotFound, nil, nil)
+		response.protocol = "HTTP/1.0"
+
+		assert.Equal(t, "HTTP/1.0 404 Not Found\r\nContent-Length: 0\r\n\r\n", string(response.ToBytes()))
+	})
+}
+
+func TestRequestFraming(t *testing.T) {
+	t.Run("should reject the ambiguous body lengths", func(t *testing.T) {
+		tests := map[string]error{
+			"Content-Length: 5\r\nTransfer-Encoding: chunked\r\n": errContentLengthWithTransferEncoding,
+			"Content-Length: 5\r\nContent-Length: 6\r\n":          errConflictingContentLength,
+			"Content-Length: 5, 6\r\n":                            errConflictingContentLength,
+			"Content-Length: +5\r\n":                              errInvalidContentLength,
+			"Content-Length: 0x5\r\n":                             errInvalidContentLength,
+		}
+
+		for headers, expected := range tests {
+			request, err := NewRequest([]byte("POST / HTTP/1.1\r\nHost: localhost\r\n" + headers + "\r\n"))
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, request.validateFraming(), expected, headers)
+		}
+	})
+
+	t.Run("should accept repeated identical content lengths", func(t *testing.T) {
+		request, err := NewRequest([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 5, 5\r\n\r\n"))
+		require.NoError(t, err)
+
+		require.NoError(t, request.validateFraming())
+		length, err := request.ContentLength()
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), length)
+	})
+
+	t.Run("should require a single Host in HTTP/1.1", func(t *testing.T) {
+		for _, raw := range []string{
+			"GET / HTTP/1.1\r\n\r\n",
+			"GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n",
+		} {
+			request, err := NewRequest([]byte(raw))
+			require.NoError(t, err)
+
+			assert.ErrorIs(t, request.validateFraming(), errInvalidHost, raw)
+		}
+
+		request, err := NewRequest([]byte("GET / HTTP/1.0\r\n\r\n"))
+		require.NoError(t, err)
+		assert.NoError(t, request.validateFraming())
+	})
+
+	t.Run("should only accept chunked as the last transfer coding", func(t *testing.T) {
+		tests := map[string]error{
+			"Transfer-Encoding: chunked\r\n":                               nil,
+			"Transfer-Encoding: gzip, chunked\r\n":                         errUnsupportedTransferEncoding,
+			"Transfer-Encoding: chunked, gzip\r\n":                         errInvalidTransferEncoding,
+			"Transfer-Encoding: chunked\r\nTransfer-Encoding: chunked\r\n": errInvalidTransferEncoding,
+			"Transfer-Encoding: identity\r\n":                              errInvalidTransferEncoding,
+		}
+
+		for headers, expected := range tests {
+			request, err := NewRequest([]byte("POST / HTTP/1.1\r\nHost: localhost\r\n" + headers + "\r\n"))
+			require.NoError(t, err)
+
+			_, err = request.chunked()
+			if expected == nil {
+				assert.NoError(t, err, headers)
+			} else {
+				assert.ErrorIs(t, err, expected, headers)
+			}
+		}
+	})
 }
@@ -2,5 +2,25 @@ package fast
 
 const (
 	MethodGet     = "GET"
+	MethodHead    = "HEAD"
+	MethodPost    = "POST"
+	MethodPut     = "PUT"
+	MethodPatch   = "PATCH"
+	MethodDelete  = "DELETE"
+	MethodConnect = "CONNECT"
 	MethodOptions = "OPTIONS"
+	MethodTrace   = "TRACE"
 )
+
+// methods lists every method registered by All.
+var methods = []string{
+	MethodGet,
+	MethodHead,
+	MethodPost,
+	MethodPut,
+	MethodPatch,
+	MethodDelete,
+	MethodConnect,
+	MethodOptions,
+	MethodTrace,
+}
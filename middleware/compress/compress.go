@@ -14,6 +14,11 @@ func New() fast.Handler {
 			return err
 		}
 
+		// the body was already sent to the client
+		if c.Streaming() {
+			return nil
+		}
+
 		encoding := c.Get("Accept-Encoding")
 		if encoding == "gzip" {
 			var buffer bytes.Buffer
@@ -0,0 +1,69 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"path"
+
+	"fast"
+)
+
+// Config lists the client certificates allowed by the middleware. The patterns follow path.Match,
+// as "*.internal.example.com" or "spiffe://example.com/ns/payments/*", where "*" matches any
+// characters except "/". Without any pattern every verified certificate is allowed.
+type Config struct {
+	// Subjects are matched against the common name and the whole subject, as "CN=billing,O=Example".
+	Subjects []string
+	// SANs are matched against the DNS names, the IP and email addresses and the URIs of the certificate.
+	SANs []string
+}
+
+// New authorizes the requests by the certificate the client authenticated with, see App.ListenMutualTLS.
+// The requests without a verified certificate or with one that isn't allowed are answered with 403.
+func New(config Config) fast.Handler {
+	return func(c *fast.Ctx) error {
+		cert := c.ClientCert()
+		if cert == nil {
+			return fast.NewError(fast.StatusForbidden, "client certificate required")
+		}
+
+		if !config.allows(cert) {
+			return fast.NewError(fast.StatusForbidden, "client certificate not allowed")
+		}
+
+		return c.Next()
+	}
+}
+
+func (config Config) allows(cert *x509.Certificate) bool {
+	if len(config.Subjects) == 0 && len(config.SANs) == 0 {
+		return true
+	}
+
+	if matchAny(config.Subjects, cert.Subject.CommonName, cert.Subject.String()) {
+		return true
+	}
+
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	return matchAny(config.SANs, names...)
+}
+
+func matchAny(patterns []string, names ...string) bool {
+	for _, pattern := range patterns {
+		for _, name := range names {
+			// a malformed pattern never matches
+			if ok, _ := path.Match(pattern, name); ok && name != "" {
+				return true
+			}
+		}
+	}
+
+	return false
+}
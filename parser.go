@@ -0,0 +1,345 @@
+package fast
+
+import (
+	"bytes"
+)
+
+// requestError is a request that doesn't follow RFC 9112, it's answered
+// with 400 Bad Request and the connection is closed right after.
+type requestError string
+
+func (e requestError) Error() string {
+	return string(e)
+}
+
+const (
+	errInvalidRequestLine    = requestError("invalid request line")
+	errInvalidMethod         = requestError("invalid method")
+	errInvalidTarget         = requestError("invalid request target")
+	errUnsupportedProtocol   = requestError("unsupported protocol version")
+	errInvalidHeaderField    = requestError("invalid header field")
+	errWhitespaceBeforeColon = requestError("whitespace between the header field name and the colon")
+	errObsoleteLineFolding   = requestError("obsolete line folding in a header field")
+	errBareLF                = requestError("line terminated by a bare LF")
+
+	errInvalidHost                       = requestError("missing or repeated Host header")
+	errInvalidContentLength              = requestError("invalid Content-Length")
+	errConflictingContentLength          = requestError("conflicting Content-Length values")
+	errContentLengthWithTransferEncoding = requestError("both Content-Length and Transfer-Encoding are set")
+	errInvalidTransferEncoding           = requestError("chunked isn't the last transfer coding")
+)
+
+// The limits of the request head, they are answered with their own status instead of 400.
+var (
+	errURITooLong          = NewError(StatusRequestURITooLong, "request target too long")
+	errHeaderTooLarge      = NewError(StatusRequestHeaderFieldsTooLarge, "request head too large")
+	errTooManyHeaderFields = NewError(StatusRequestHeaderFieldsTooLarge, "too many header fields")
+)
+
+// tokenChars are the bytes allowed in methods and header field names (RFC 9110 section 5.6.2).
+var tokenChars = [256]bool{
+	'!': true, '#': true, '$': true, '%': true, '&': true, '\'': true, '*': true,
+	'+': true, '-': true, '.': true, '^': true, '_': true, '`': true, '|': true, '~': true,
+}
+
+func init() {
+	for c := '0'; c <= '9'; c++ {
+		tokenChars[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		tokenChars[c] = true
+		tokenChars[c-'a'+'A'] = true
+	}
+}
+
+type parseState int
+
+const (
+	stateStart           parseState = iota // skipping the empty lines before the request line
+	stateStartLF                           // got the CR of an empty line, expecting LF
+	stateMethod                            // reading the method
+	stateTarget                            // reading the request target
+	stateProtocol                          // reading the protocol
+	stateRequestLineEnd                    // got the CR of the request line, expecting LF
+	stateFieldStart                        // start of a header field or of the blank line ending the head
+	stateFieldKey                          // reading the key until the colon
+	stateFieldValueStart                   // skipping the whitespace before the value
+	stateFieldValue                        // reading the value until the end of the line
+	stateHeadEnd                           // got the CR of the blank line, expecting LF
+	stateDone
+)
+
+// span is the position of a token in the buffer being parsed.
+type span struct {
+	start int
+	end   int
+}
+
+type fieldSpan struct {
+	key   span
+	value span
+}
+
+// parser is an incremental state machine for the head (request line and header fields)
+// of an HTTP/1.1 request. It only records the position of each token, so parsing
+// doesn't allocate once the slice of fields has grown to the number of headers.
+//
+// The syntax of RFC 9112 is enforced: the lines must end with CRLF, the method and the field
+// names must be tokens, the obsolete line folding isn't accepted and neither are control
+// characters in the target or the values, since lenient parsers enable request smuggling.
+//
+// The parsing is resumable: when the buffer ends in the middle of the head, parse returns
+// false and the next call continues where it stopped, as long as it receives the same
+// bytes followed by the new ones. The positions are relative to the start of the buffer.
+type parser struct {
+	state    parseState
+	pos      int // next byte to parse
+	method   span
+	target   span
+	protocol span
+	field    fieldSpan // field being parsed
+	fields   []fieldSpan
+
+	maxTargetLength int // 0 for no limit
+	maxFields       int // 0 for no limit
+}
+
+// reset prepares the parser for the next request, keeping its limits.
+func (p *parser) reset() {
+	*p = parser{
+		fields:          p.fields[:0],
+		maxTargetLength: p.maxTargetLength,
+		maxFields:       p.maxFields,
+	}
+}
+
+// parse continues parsing the head in data and reports whether it's complete,
+// in which case p.pos is the length of the head and the body starts right after it.
+func (p *parser) parse(data []byte) (bool, error) {
+	for i := p.pos; i < len(data); i++ {
+		c := data[i]
+
+		switch p.state {
+		case stateStart:
+			// empty lines before the request line are ignored (RFC 9112 section 2.2)
+			switch c {
+			case '\r':
+				p.state = stateStartLF
+				continue
+			case '\n':
+				return false, errBareLF
+			}
+			p.method.start = i
+			p.state = stateMethod
+			i-- // the byte is the first of the method
+
+		case stateStartLF:
+			if c != '\n' {
+				return false, errBareLF
+			}
+			p.state = stateStart
+
+		case stateMethod:
+			switch {
+			case c == ' ':
+				if i == p.method.start {
+					return false, errInvalidRequestLine
+				}
+				p.method.end = i
+				p.target.start = i + 1
+				p.state = stateTarget
+			case !tokenChars[c]:
+				return false, errInvalidMethod
+			}
+
+		case stateTarget:
+			switch {
+			case c == ' ':
+				if i == p.target.start {
+					return false, errInvalidRequestLine
+				}
+				p.target.end = i
+				if err := validateTarget(data[p.method.start:p.method.end], data[p.target.start:p.target.end]); err != nil {
+					return false, err
+				}
+				p.protocol.start = i + 1
+				p.state = stateProtocol
+			case c < 0x21 || c > 0x7e:
+				return false, errInvalidTarget
+			case p.maxTargetLength > 0 && i-p.target.start >= p.maxTargetLength:
+				return false, errURITooLong
+			}
+
+		case stateProtocol:
+			switch c {
+			case '\r':
+				p.protocol.end = i
+				if err := validateProtocol(data[p.protocol.start:p.protocol.end]); err != nil {
+					return false, err
+				}
+				p.state = stateRequestLineEnd
+			case '\n':
+				return false, errBareLF
+			case ' ':
+				return false, errInvalidRequestLine
+			}
+
+		case stateRequestLineEnd:
+			if c != '\n' {
+				return false, errBareLF
+			}
+			p.state = stateFieldStart
+
+		case stateFieldStart:
+			switch c {
+			case '\r':
+				p.state = stateHeadEnd
+			case '\n':
+				return false, errBareLF
+			case ' ', '\t':
+				return false, errObsoleteLineFolding
+			default:
+				p.field = fieldSpan{key: span{start: i}}
+				p.state = stateFieldKey
+				i-- // the byte is the first of the key
+			}
+
+		case stateFieldKey:
+			switch {
+			case c == ':':
+				if i == p.field.key.start {
+					return false, errInvalidHeaderField
+				}
+				p.field.key.end = i
+				p.state = stateFieldValueStart
+			case c == ' ' || c == '\t':
+				return false, errWhitespaceBeforeColon
+			case !tokenChars[c]:
+				return false, errInvalidHeaderField
+			}
+
+		case stateFieldValueStart:
+			if c == ' ' || c == '\t' {
+				continue
+			}
+			p.field.value.start = i
+			p.state = stateFieldValue
+			i-- // the byte is part of the value (or ends an empty one)
+
+		case stateFieldValue:
+			// the values are the longest part of the head, so the end of the line is searched at once
+			j := bytes.IndexByte(data[i:], '\n')
+			if j < 0 {
+				i = len(data) - 1
+				continue
+			}
+			i += j
+
+			if data[i-1] != '\r' {
+				return false, errBareLF
+			}
+
+			end := i - 1
+			for _, c := range data[p.field.value.start:end] {
+				// only visible characters, whitespace and obs-text (RFC 9110 section 5.5)
+				if (c < 0x20 && c != '\t') || c == 0x7f {
+					return false, errInvalidHeaderField
+				}
+			}
+			for end > p.field.value.start && (data[end-1] == ' ' || data[end-1] == '\t') {
+				end--
+			}
+			p.field.value.end = end
+			if p.maxFields > 0 && len(p.fields) == p.maxFields {
+				return false, errTooManyHeaderFields
+			}
+			p.fields = append(p.fields, p.field)
+			p.state = stateFieldStart
+
+		case stateHeadEnd:
+			if c != '\n' {
+				return false, errBareLF
+			}
+			p.pos = i + 1
+			p.state = stateDone
+			return true, nil
+
+		case stateDone:
+			return true, nil
+		}
+	}
+
+	p.pos = len(data)
+	return p.state == stateDone, nil
+}
+
+// validateTarget checks the form of the request target (RFC 9112 section 3.2): a path, "*" for
+// OPTIONS, the authority for CONNECT and only for it, or an absolute URI as sent to proxies.
+func validateTarget(method, target []byte) error {
+	switch {
+	case string(method) == MethodConnect:
+		if !authorityForm(target) {
+			return errInvalidTarget
+		}
+	case target[0] == '/':
+	case len(target) == 1 && target[0] == '*':
+		// "PRI *" starts the HTTP/2 preface, it's detected once its version is rejected
+		if string(method) != MethodOptions && string(method) != "PRI" {
+			return errInvalidTarget
+		}
+	case !absoluteForm(target):
+		return errInvalidTarget
+	}
+
+	return nil
+}
+
+// authorityForm reports whether the target is a host followed by a port, as "example.com:443".
+func authorityForm(target []byte) bool {
+	i := bytes.LastIndexByte(target, ':')
+	if i <= 0 || i == len(target)-1 || bytes.ContainsAny(target[:i], "/?#@") {
+		return false
+	}
+
+	for _, c := range target[i+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// absoluteForm reports whether the target is an absolute URI with an authority, as "http://example.com/a".
+func absoluteForm(target []byte) bool {
+	scheme, rest, ok := bytes.Cut(target, []byte("://"))
+	if !ok || len(scheme) == 0 || len(rest) == 0 || rest[0] == '/' {
+		return false
+	}
+
+	for i, c := range scheme {
+		letter := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if !letter && (i == 0 || !('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.')) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateProtocol accepts "HTTP/1.x", the only versions this parser understands.
+func validateProtocol(protocol []byte) error {
+	if len(protocol) != len("HTTP/1.1") || !bytes.HasPrefix(protocol, []byte("HTTP/")) ||
+		protocol[6] != '.' || protocol[5] < '0' || protocol[5] > '9' || protocol[7] < '0' || protocol[7] > '9' {
+		return errInvalidRequestLine
+	}
+
+	if protocol[5] != '1' {
+		return errUnsupportedProtocol
+	}
+
+	return nil
+}
+
+// started reports whether any byte of the request line was parsed.
+func (p *parser) started() bool {
+	return p.state != stateStart
+}
@@ -1,5 +1,297 @@
 package fast
 
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
 type Router interface {
 	Add(method string, path string, handlers ...Handler) Router
+	Get(path string, handlers ...Handler) Router
+	Head(path string, handlers ...Handler) Router
+	Post(path string, handlers ...Handler) Router
+	Put(path string, handlers ...Handler) Router
+	Patch(path string, handlers ...Handler) Router
+	Delete(path string, handlers ...Handler) Router
+	Connect(path string, handlers ...Handler) Router
+	Options(path string, handlers ...Handler) Router
+	Trace(path string, handlers ...Handler) Router
+	All(path string, handlers ...Handler) Router
+	Route(path string) *Route
+	Use(handlers ...Handler) Router
+	Group(prefix string, handlers ...Handler) *Group
+}
+
+var (
+	_ Router = (*App)(nil)
+	_ Router = (*Group)(nil)
+)
+
+// Route registers several methods for the same path, as in
+// app.Route("/items").Get(list).Post(create).
+type Route struct {
+	router Router
+	path   string
+}
+
+func (r *Route) Get(handlers ...Handler) *Route {
+	r.router.Add(MethodGet, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Head(handlers ...Handler) *Route {
+	r.router.Add(MethodHead, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Post(handlers ...Handler) *Route {
+	r.router.Add(MethodPost, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Put(handlers ...Handler) *Route {
+	r.router.Add(MethodPut, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Patch(handlers ...Handler) *Route {
+	r.router.Add(MethodPatch, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Delete(handlers ...Handler) *Route {
+	r.router.Add(MethodDelete, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Connect(handlers ...Handler) *Route {
+	r.router.Add(MethodConnect, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Options(handlers ...Handler) *Route {
+	r.router.Add(MethodOptions, r.path, handlers...)
+	return r
+}
+
+func (r *Route) Trace(handlers ...Handler) *Route {
+	r.router.Add(MethodTrace, r.path, handlers...)
+	return r
+}
+
+func (r *Route) All(handlers ...Handler) *Route {
+	r.router.All(r.path, handlers...)
+	return r
+}
+
+// node is a tree of path segments, the app keeps one tree per method.
+// A segment is either static ("users"), a param (":id") or a wildcard ("*") matching the rest of the path.
+type node struct {
+	static   map[string]*node
+	param    *node
+	wildcard *node
+	handlers []Handler
+	params   []string // names of the route params, in the order they appear in the path
+	path     string   // the route as it was registered
+	group    *Group   // group the route was registered in, nil for the app
+}
+
+func newNode() *node {
+	return &node{static: make(map[string]*node)}
+}
+
+// insert adds the route to the tree and returns its node, failing when it's
+// invalid or when another route already matches exactly the same paths.
+func (n *node) insert(path string, handlers []Handler) (*node, error) {
+	current := n
+	var params []string
+
+	segments := splitPath(path)
+	for i, segment := range segments {
+		switch {
+		case segment == "*":
+			if i != len(segments)-1 {
+				return nil, fmt.Errorf("route %q: the wildcard must be the last segment", path)
+			}
+			if current.wildcard == nil {
+				current.wildcard = newNode()
+			}
+			current = current.wildcard
+			params = append(params, "*")
+
+		case strings.HasPrefix(segment, ":"):
+			name := segment[1:]
+			if name == "" {
+				return nil, fmt.Errorf("route %q: missing the param name", path)
+			}
+			if slices.Contains(params, name) {
+				return nil, fmt.Errorf("route %q: duplicated param %q", path, name)
+			}
+			if current.param == nil {
+				current.param = newNode()
+			}
+			current = current.param
+			params = append(params, name)
+
+		default:
+			child, ok := current.static[segment]
+			if !ok {
+				child = newNode()
+				current.static[segment] = child
+			}
+			current = child
+		}
+	}
+
+	if current.handlers != nil {
+		return nil, fmt.Errorf("route %q conflicts with the route %q", path, current.path)
+	}
+
+	current.handlers = handlers
+	current.params = params
+	current.path = path
+	return current, nil
+}
+
+// find returns the node of the route matching the path and the values of its params.
+// Static segments are tried first, then params and at last the wildcard. The path is still
+// percent-encoded, so an encoded "/" stays in its segment, which is decoded to be matched.
+// A trailing slash is ignored, but the empty segments of "//" are kept so "//admin" isn't "/admin".
+// The dot segments are resolved first and a value can't hold an encoded one, as "a%2F..%2Fb",
+// so a wildcard serving files never climbs out of its directory.
+func (n *node) find(path string) (*node, []string) {
+	return n.match(strings.TrimPrefix(removeDotSegments(path), "/"), nil)
+}
+
+func (n *node) match(path string, values []string) (*node, []string) {
+	if path == "" {
+		if n.handlers != nil {
+			return n, values
+		}
+		if n.wildcard != nil && n.wildcard.handlers != nil {
+			return n.wildcard, append(values, "")
+		}
+		return nil, nil
+	}
+
+	raw, rest, _ := strings.Cut(path, "/")
+	segment := unescapeSegment(raw)
+	if segment != raw && hasParentSegment(segment) {
+		return nil, nil
+	}
+
+	if child, ok := n.static[segment]; ok {
+		if found, values := child.match(rest, values); found != nil {
+			return found, values
+		}
+	}
+
+	if n.param != nil && segment != "" {
+		if found, values := n.param.match(rest, append(values, segment)); found != nil {
+			return found, values
+		}
+	}
+
+	if n.wildcard != nil && n.wildcard.handlers != nil {
+		if value := unescapeSegment(path); value == path || !hasParentSegment(value) {
+			return n.wildcard, append(values, value)
+		}
+	}
+
+	return nil, nil
+}
+
+// removeDotSegments resolves the "." and ".." segments of the raw path, encoded or not,
+// as RFC 3986 section 5.2.4, so "/static/../admin" is routed as "/admin".
+func removeDotSegments(path string) string {
+	// a dot segment always starts after a slash
+	if !strings.Contains(path, "/.") && !strings.Contains(path, "/%2e") && !strings.Contains(path, "/%2E") {
+		return path
+	}
+
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	resolved := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		switch unescapeSegment(segment) {
+		case ".":
+		case "..":
+			if len(resolved) > 0 {
+				resolved = resolved[:len(resolved)-1]
+			}
+		default:
+			resolved = append(resolved, segment)
+			continue
+		}
+
+		// "/docs/.." is "/", the last segment removed leaves a trailing slash
+		if i == len(segments)-1 {
+			resolved = append(resolved, "")
+		}
+	}
+
+	return "/" + strings.Join(resolved, "/")
+}
+
+// hasParentSegment reports whether a decoded value has a ".." segment, only possible
+// with encoded slashes once the dot segments of the path were removed.
+func hasParentSegment(value string) bool {
+	for segment := range strings.SplitSeq(value, "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// unescapeSegment decodes a part of a path, the parser already rejected the invalid escapes.
+func unescapeSegment(s string) string {
+	if strings.IndexByte(s, '%') < 0 {
+		return s
+	}
+
+	decoded, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+// expandOptional returns every path an optional param (":name?") can produce,
+// "/docs/:lang?" becomes "/docs/:lang" and "/docs".
+func expandOptional(path string) []string {
+	segments := splitPath(path)
+	paths := []string{""}
+
+	for _, segment := range segments {
+		optional := strings.HasPrefix(segment, ":") && strings.HasSuffix(segment, "?")
+		segment = strings.TrimSuffix(segment, "?")
+
+		expanded := make([]string, 0, len(paths)*2)
+		for _, p := range paths {
+			expanded = append(expanded, p+"/"+segment)
+			if optional {
+				expanded = append(expanded, p)
+			}
+		}
+		paths = expanded
+	}
+
+	for i, p := range paths {
+		if p == "" {
+			paths[i] = "/"
+		}
+	}
+
+	return paths
 }
@@ -0,0 +1,214 @@
+package fast
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	handler := func(c *Ctx) error { return nil }
+
+	newTree := func(t *testing.T, paths ...string) *node {
+		root := newNode()
+		for _, path := range paths {
+			for _, expanded := range expandOptional(path) {
+				_, err := root.insert(expanded, []Handler{handler})
+				require.NoError(t, err)
+			}
+		}
+		return root
+	}
+
+	t.Run("should match static, params and wildcard by priority", func(t *testing.T) {
+		root := newTree(t,
+			"/",
+			"/users/me",
+			"/users/:id",
+			"/users/:id/posts/:post",
+			"/static/*",
+			"/files/:name",
+			"/files/*",
+		)
+
+		tests := []struct {
+			path   string
+			route  string
+			values []string
+		}{
+			{"/", "/", nil},
+			{"/users/me", "/users/me", nil},
+			{"/users/42", "/users/:id", []string{"42"}},
+			{"/users/42/", "/users/:id", []string{"42"}},
+			{"/users/42/posts/7", "/users/:id/posts/:post", []string{"42", "7"}},
+			{"/static", "/static/*", []string{""}},
+			{"/static/css/main.css", "/static/*", []string{"css/main.css"}},
+			{"/files/report.pdf", "/files/:name", []string{"report.pdf"}},
+			{"/files/2024/report.pdf", "/files/*", []string{"2024/report.pdf"}},
+		}
+
+		for _, tt := range tests {
+			route, values := root.find(tt.path)
+			require.NotNil(t, route, tt.path)
+
+			assert.Equal(t, tt.route, route.path, tt.path)
+			assert.Equal(t, tt.values, values, tt.path)
+		}
+	})
+
+	t.Run("should backtrack when the static branch doesn't match", func(t *testing.T) {
+		root := newTree(t, "/users/me/settings", "/users/:id/posts")
+
+		route, values := root.find("/users/me/posts")
+		require.NotNil(t, route)
+
+		assert.Equal(t, "/users/:id/posts", route.path)
+		assert.Equal(t, []string{"me"}, values)
+	})
+
+	t.Run("should keep the encoded slashes in their segment", func(t *testing.T) {
+		root := newTree(t, "/users/:id", "/users/:id/posts", "/static/*", "/café")
+
+		tests := []struct {
+			path   string
+			route  string
+			values []string
+		}{
+			{"/users/x%2Fposts", "/users/:id", []string{"x/posts"}},
+			{"/users/x%2Fposts/posts", "/users/:id/posts", []string{"x/posts"}},
+			{"/static/a%2F.%2Fb", "/static/*", []string{"a/./b"}},
+			{"/caf%C3%A9", "/café", nil},
+		}
+
+		for _, tt := range tests {
+			route, values := root.find(tt.path)
+			require.NotNil(t, route, tt.path)
+
+			assert.Equal(t, tt.route, route.path, tt.path)
+			assert.Equal(t, tt.values, values, tt.path)
+		}
+	})
+
+	t.Run("should resolve the dot segments before matching", func(t *testing.T) {
+		root := newTree(t, "/users/:id", "/static/*", "/admin")
+
+		tests := []struct {
+			path   string
+			route  string
+			values []string
+		}{
+			{"/static/../admin", "/admin", nil},
+			{"/static/css/../../admin/.", "/admin", nil},
+			{"/static/%2e%2E/admin", "/admin", nil},
+			{"/../../admin", "/admin", nil},
+			{"/static/./css/../main.css", "/static/*", []string{"main.css"}},
+		}
+
+		for _, tt := range tests {
+			route, values := root.find(tt.path)
+			require.NotNil(t, route, tt.path)
+
+			assert.Equal(t, tt.route, route.path, tt.path)
+			assert.Equal(t, tt.values, values, tt.path)
+		}
+	})
+
+	t.Run("should not match the values climbing with encoded slashes", func(t *testing.T) {
+		root := newTree(t, "/users/:id", "/static/*")
+
+		for _, path := range []string{"/static/a%2F..%2F..%2Fetc%2Fpasswd", "/static/..%2Fetc", "/users/x%2F.."} {
+			route, _ := root.find(path)
+			assert.Nil(t, route, path)
+		}
+	})
+
+	t.Run("should match the optional params with and without a value", func(t *testing.T) {
+		root := newTree(t, "/docs/:lang?")
+
+		route, values := root.find("/docs/en")
+		require.NotNil(t, route)
+		assert.Equal(t, []string{"lang"}, route.params)
+		assert.Equal(t, []string{"en"}, values)
+
+		route, values = root.find("/docs")
+		require.NotNil(t, route)
+		assert.Empty(t, route.params)
+		assert.Empty(t, values)
+	})
+
+	t.Run("should not match unknown paths", func(t *testing.T) {
+		root := newTree(t, "/users/:id", "/static/*")
+
+		for _, path := range []string{"/", "/users", "/users/1/posts", "/unknown", "//users/1", "/users//1", "/users/1//", "/users/1/.."} {
+			route, _ := root.find(path)
+			assert.Nil(t, route, path)
+		}
+	})
+
+	t.Run("should detect the conflicts and invalid routes", func(t *testing.T) {
+		tests := []struct {
+			existing string
+			path     string
+		}{
+			{"/users/:id", "/users/:name"},
+			{"/users", "/users/"},
+			{"/docs", "/docs/:lang?"},
+			{"", "/static/*/files"},
+			{"", "/users/:"},
+			{"", "/users/:id/posts/:id"},
+		}
+
+		for _, tt := range tests {
+			root := newNode()
+			if tt.existing != "" {
+				_, err := root.insert(tt.existing, []Handler{handler})
+				require.NoError(t, err)
+			}
+
+			var err error
+			for _, expanded := range expandOptional(tt.path) {
+				if _, err = root.insert(expanded, []Handler{handler}); err != nil {
+					break
+				}
+			}
+			assert.Error(t, err, tt.path)
+		}
+	})
+
+	t.Run("should panic when registering a conflicting route", func(t *testing.T) {
+		app := New(Config{})
+		app.Get("/users/:id", handler)
+
+		assert.Panics(t, func() {
+			app.Get("/users/:name", handler)
+		})
+	})
+}
+
+func BenchmarkRouter(b *testing.B) {
+	handler := func(c *Ctx) error { return nil }
+
+	root := newNode()
+	for _, path := range []string{
+		"/",
+		"/users",
+		"/users/:id",
+		"/users/:id/posts",
+		"/users/:id/posts/:post",
+		"/static/*",
+		"/api/v1/orders",
+		"/api/v1/orders/:id/items",
+	} {
+		if _, err := root.insert(path, []Handler{handler}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportAllocs()
+	for b.Loop() {
+		root.find("/users/42/posts/7")
+		root.find("/api/v1/orders/10/items")
+		root.find("/static/css/main.css")
+	}
+}
@@ -1,21 +1,44 @@
 package fast
 
 const (
+	StatusContinue = 100
+
 	StatusOK        = 200
 	StatusNoContent = 204
 
-	StatusBadRequest = 400
-	StatusNotFound   = 404
+	StatusBadRequest            = 400
+	StatusForbidden             = 403
+	StatusNotFound              = 404
+	StatusMethodNotAllowed      = 405
+	StatusRequestEntityTooLarge = 413
+	StatusRequestURITooLong     = 414
+	StatusExpectationFailed     = 417
+
+	StatusTooManyRequests             = 429
+	StatusRequestHeaderFieldsTooLarge = 431
 
 	StatusInternalServerError = 500
+	StatusNotImplemented      = 501
 	StatusServiceUnavailable  = 503
 )
 
 var StatusText = map[int]string{
+	100: "Continue",
+
 	200: "OK",
+	204: "No Content",
 
 	400: "Bad Request",
+	403: "Forbidden",
 	404: "Not Found",
+	405: "Method Not Allowed",
+	413: "Payload Too Large",
+	414: "URI Too Long",
+	417: "Expectation Failed",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
 
 	500: "Internal Server Error",
+	501: "Not Implemented",
+	503: "Service Unavailable",
 }
@@ -0,0 +1,601 @@
+package fast
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// http2Stream is a request of an HTTP/2 connection and its response.
+type http2Stream struct {
+	sc      *http2Conn
+	id      uint32
+	request Request
+
+	// guarded by the lock of the connection
+	body          bytes.Buffer // data received and not read yet
+	bodyErr       error        // returned once the body is read, io.EOF when the client ended the stream
+	trailers      []headerField
+	remoteEnded   bool // END_STREAM received
+	localEnded    bool // END_STREAM sent
+	reset         bool // RST_STREAM sent or received
+	sendWindow    int64
+	recvWindow    int64
+	recvUnacked   int64
+	length        int64 // Content-Length of the request, -1 without it
+	received      int64
+	readDeadline  time.Time
+	writeDeadline time.Time
+	timers        []*time.Timer
+}
+
+func (sc *http2Conn) newStream(id uint32, endStream bool) *http2Stream {
+	st := &http2Stream{
+		sc:          sc,
+		id:          id,
+		remoteEnded: endStream,
+		recvWindow:  http2StreamWindowSize,
+		length:      -1,
+	}
+	st.request.Protocol = "HTTP/2.0"
+	st.request.h2 = st
+	if endStream {
+		st.bodyErr = io.EOF
+	}
+
+	return st
+}
+
+// startStream runs the handlers of the stream in their own goroutine,
+// or the error handler when the request couldn't be read.
+func (sc *http2Conn) startStream(st *http2Stream, readErr error) {
+	now := time.Now()
+	st.readDeadline = deadline(now, sc.app.config.ReadTimeout)
+	st.writeDeadline = deadline(now, sc.app.config.WriteTimeout)
+
+	sc.mu.Lock()
+	st.sendWindow = sc.initialWindow
+	for _, d := range []time.Time{st.readDeadline, st.writeDeadline} {
+		if !d.IsZero() {
+			st.timers = append(st.timers, time.AfterFunc(d.Sub(now), sc.broadcast))
+		}
+	}
+
+	sc.streams[st.id] = st
+	if len(sc.streams) == 1 {
+		sc.idleTimer.Stop()
+		sc.app.setIdle(sc.conn, false)
+	}
+	sc.mu.Unlock()
+
+	sc.handlers.Add(1)
+	go sc.runStream(st, readErr)
+}
+
+func (sc *http2Conn) runStream(st *http2Stream, readErr error) {
+	defer sc.handlers.Done()
+
+	var err error
+	if readErr != nil {
+		err = sc.app.handleReadError(sc.conn, &st.request, readErr)
+	} else {
+		err = sc.app.handleRequest(sc.conn, &st.request)
+	}
+	if err != nil {
+		slog.Debug("failed to write the response of the HTTP/2 stream", "stream", st.id, "error", err)
+	}
+
+	sc.closeStream(st)
+}
+
+// closeStream forgets a stream once its handlers returned. The stream is reset when the
+// response wasn't sent completely, or when the rest of the request body isn't needed.
+func (sc *http2Conn) closeStream(st *http2Stream) {
+	sc.mu.Lock()
+	for _, timer := range st.timers {
+		timer.Stop()
+	}
+
+	code, reset := errCodeNo, false
+	if !st.reset {
+		switch {
+		case !st.localEnded:
+			code, reset = errCodeInternal, true
+		case !st.remoteEnded:
+			// the response is complete, so the client can stop sending the body (RFC 9113 section 8.1)
+			reset = true
+		}
+		st.reset = reset
+	}
+
+	delete(sc.streams, st.id)
+
+	// the data never read is given back to the connection window
+	sc.recvUnacked += int64(st.body.Len())
+	st.body.Reset()
+	connIncrement := sc.connWindowUpdate()
+
+	idle := len(sc.streams) == 0
+	closing := idle && (sc.goingAway || sc.app.shuttingDown.Load())
+	if idle && !closing {
+		sc.app.setIdle(sc.conn, true)
+		sc.idleTimer.Reset(sc.app.config.IdleTimeout)
+	}
+	sc.mu.Unlock()
+
+	if reset || connIncrement > 0 {
+		err := sc.write(func() error {
+			if reset {
+				if err := sc.framer.writeRSTStream(st.id, code); err != nil {
+					return err
+				}
+			}
+			if connIncrement > 0 {
+				return sc.framer.writeWindowUpdate(0, connIncrement)
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Debug("failed to close the HTTP/2 stream", "stream", st.id, "error", err)
+		}
+	}
+
+	// the client was told not to open more streams, the last one ended
+	if closing {
+		sc.goAway(errCodeNo, "")
+		sc.conn.Conn.Close()
+	}
+}
+
+// readHeaders fills the request with the fields of its header block. The malformed requests
+// (RFC 9113 section 8.1.1) are stream errors, while the ones over the limits of the Config
+// are returned to be answered by the error handler as for HTTP/1.
+func (st *http2Stream) readHeaders(fields []headerField) error {
+	config := &st.sc.app.config
+	r := &st.request
+
+	// the limits are checked first, the fields of a request refused aren't worth validating
+	size, count, cookies := 0, 0, false
+	for _, field := range fields {
+		size += entrySize(field.key, field.value)
+		switch {
+		case field.key == "cookie":
+			cookies = true
+		case !strings.HasPrefix(field.key, ":"):
+			count++
+		}
+	}
+	if cookies {
+		count++
+	}
+
+	switch {
+	case size > config.MaxHeaderBytes:
+		return errHeaderTooLarge
+	case count > config.MaxHeaderCount:
+		return errTooManyHeaderFields
+	}
+
+	malformed := func(reason string) error {
+		return streamError{st.id, errCodeProtocol, reason}
+	}
+
+	var authority, scheme, path string
+	var pseudo []string // the pseudo-header fields seen
+	var cookie []string
+	regular := false
+	for _, field := range fields {
+		if !validHTTP2FieldName(field.key) || !validFieldValue(field.value) {
+			return malformed("invalid header field")
+		}
+
+		if field.key[0] == ':' {
+			if regular {
+				return malformed("pseudo-header field after a regular one")
+			}
+			if containsFold(pseudo, field.key) {
+				return malformed("repeated pseudo-header field")
+			}
+			pseudo = append(pseudo, field.key)
+
+			switch field.key {
+			case ":method":
+				r.Method = field.value
+			case ":scheme":
+				scheme = field.value
+			case ":authority":
+				authority = field.value
+			case ":path":
+				path = field.value
+			default:
+				return malformed("unknown pseudo-header field")
+			}
+			continue
+		}
+
+		regular = true
+		switch field.key {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return malformed("connection-specific header field")
+		case "te":
+			if field.value != "trailers" {
+				return malformed("TE header other than trailers")
+			}
+		case "cookie":
+			// the cookies can be split in several fields to be compressed better (RFC 9113 section 8.2.3)
+			cookie = append(cookie, field.value)
+			continue
+		}
+
+		r.headers.Add(field.key, field.value)
+	}
+
+	if r.Method == "" {
+		return malformed("missing :method")
+	}
+
+	if r.Method == MethodConnect {
+		if authority == "" || scheme != "" || path != "" {
+			return malformed("invalid CONNECT request")
+		}
+		path = authority
+	} else if scheme == "" || (path == "" || path[0] != '/') && (path != "*" || r.Method != MethodOptions) {
+		return malformed("missing or invalid :scheme or :path")
+	}
+
+	if len(cookie) > 0 {
+		r.headers.Add("cookie", strings.Join(cookie, "; "))
+	}
+
+	if authority != "" && !r.headers.Has("host") {
+		r.headers.Add("host", authority)
+	}
+
+	target, rawQuery, _ := strings.Cut(path, "?")
+	r.RawQuery = rawQuery
+
+	if len(path) > config.MaxURILength {
+		return errURITooLong
+	}
+
+	decoded, err := url.PathUnescape(target)
+	if err != nil {
+		return errInvalidTarget
+	}
+	r.Path, r.rawPath = decoded, target
+
+	if err := r.validateFraming(); err != nil {
+		return err
+	}
+
+	if r.headers.Has("content-length") {
+		length, err := r.ContentLength()
+		if err != nil {
+			return err
+		}
+
+		if st.remoteEnded && length > 0 {
+			return malformed("body shorter than Content-Length")
+		}
+		st.length = length
+	}
+
+	if !st.remoteEnded {
+		// rejected before "100 Continue", so the client doesn't even send the body
+		if st.length > config.BodyLimit {
+			return ErrBodyTooLarge
+		}
+		r.body = newBodyReader(st, st.length, config.BodyLimit)
+	}
+
+	if expect := r.GetHeader("expect"); expect != "" {
+		if !strings.EqualFold(expect, "100-continue") {
+			return errExpectationFailed
+		}
+		if r.body != nil {
+			r.body.expectContinue(st.writeContinue)
+		}
+	}
+
+	return nil
+}
+
+// validHTTP2FieldName reports whether the key is a token in lower case, as HTTP/2 requires,
+// optionally after the colon of a pseudo-header field.
+func validHTTP2FieldName(key string) bool {
+	key = strings.TrimPrefix(key, ":")
+	if key == "" {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if !tokenChars[key[i]] || ('A' <= key[i] && key[i] <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// validFieldValue rejects the control characters, CR and LF included, as the HTTP/1 parser does.
+func validFieldValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; (c < 0x20 && c != '\t') || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// endRemote ends the request body once the client sent END_STREAM, it must be called holding the lock.
+func (st *http2Stream) endRemote() error {
+	st.remoteEnded = true
+	st.sc.cond.Broadcast()
+
+	if st.length >= 0 && st.received != st.length {
+		return streamError{st.id, errCodeProtocol, "body shorter than Content-Length"}
+	}
+
+	if st.bodyErr == nil {
+		st.bodyErr = io.EOF
+	}
+	return nil
+}
+
+// Read reads the request body as it's received, the client is allowed
+// to send more once half of the stream window was read.
+func (st *http2Stream) Read(p []byte) (int, error) {
+	sc := st.sc
+	sc.mu.Lock()
+
+	for st.body.Len() == 0 && st.bodyErr == nil {
+		if !st.readDeadline.IsZero() && !time.Now().Before(st.readDeadline) {
+			sc.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		sc.cond.Wait()
+	}
+
+	if st.body.Len() == 0 {
+		err := st.bodyErr
+		if err == io.EOF {
+			// the trailers are only available once the body was read
+			for _, field := range st.trailers {
+				st.request.trailers.Add(field.key, field.value)
+			}
+			st.trailers = nil
+		}
+
+		sc.mu.Unlock()
+		return 0, err
+	}
+
+	n, _ := st.body.Read(p)
+	st.recvUnacked += int64(n)
+	sc.recvUnacked += int64(n)
+
+	var streamIncrement uint32
+	if !st.remoteEnded && st.recvUnacked >= http2StreamWindowSize/2 {
+		streamIncrement = uint32(st.recvUnacked)
+		st.recvWindow += st.recvUnacked
+		st.recvUnacked = 0
+	}
+	connIncrement := sc.connWindowUpdate()
+	sc.mu.Unlock()
+
+	if streamIncrement > 0 || connIncrement > 0 {
+		err := sc.write(func() error {
+			if streamIncrement > 0 {
+				if err := sc.framer.writeWindowUpdate(st.id, streamIncrement); err != nil {
+					return err
+				}
+			}
+			if connIncrement > 0 {
+				return sc.framer.writeWindowUpdate(0, connIncrement)
+			}
+			return nil
+		})
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// writable returns why the response can't be written anymore, it must be called holding the lock.
+func (st *http2Stream) writable() error {
+	switch {
+	case st.reset || st.sc.closed:
+		return errStreamClosed
+	case st.localEnded:
+		return errStreamEnded
+	case !st.writeDeadline.IsZero() && !time.Now().Before(st.writeDeadline):
+		return os.ErrDeadlineExceeded
+	}
+
+	return nil
+}
+
+// writeFrames writes frames of the stream unless it can't be written anymore,
+// the frames get the max frame size of the client.
+func (st *http2Stream) writeFrames(endStream bool, frames func(maxFrameSize int) error) error {
+	sc := st.sc
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+
+	// checked with wmu held, so nothing is written after a RST_STREAM
+	sc.mu.Lock()
+	err := st.writable()
+	maxFrameSize := sc.maxFrameSize
+	if err == nil && endStream {
+		st.localEnded = true
+	}
+	sc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return sc.flush(func() error { return frames(maxFrameSize) })
+}
+
+// writeFields writes a header block with the status, the head of a response or its trailers when it's 0.
+// The fields specific to HTTP/1 connections are left out and the keys are sent in lower case.
+func (st *http2Stream) writeFields(status int, fields *Header, endStream bool) error {
+	sc := st.sc
+	return st.writeFrames(endStream, func(maxFrameSize int) error {
+		block := sc.headerBuf[:0]
+		if status != 0 {
+			block = sc.encoder.appendField(block, ":status", strconv.Itoa(status))
+		}
+
+		if fields != nil {
+			for key, value := range fields.All() {
+				key = strings.ToLower(key)
+				switch key {
+				case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+					continue
+				}
+				block = sc.encoder.appendField(block, key, value)
+			}
+		}
+		sc.headerBuf = block
+
+		return sc.framer.writeHeaders(st.id, endStream, block, maxFrameSize)
+	})
+}
+
+// writeContinue sends "100 Continue" before the client sends the body.
+func (st *http2Stream) writeContinue() error {
+	return st.writeFields(StatusContinue, nil, false)
+}
+
+// writeResponse sends a whole response, the body is left out for HEAD requests.
+func (st *http2Stream) writeResponse(response *Response, head bool) error {
+	body := response.body
+	if head {
+		body = nil
+	}
+
+	if err := st.writeFields(response.statusCode, response.headers, len(body) == 0); err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		return nil
+	}
+
+	_, err := st.writeData(body, true)
+	return err
+}
+
+// writeData sends the data in DATA frames as the flow-control windows of the client allow it.
+func (st *http2Stream) writeData(p []byte, endStream bool) (int, error) {
+	written := 0
+	for {
+		n, err := st.awaitWindow(len(p))
+		if err != nil {
+			return written, err
+		}
+
+		data := p[:n]
+		end := endStream && n == len(p)
+		if err := st.writeFrames(end, func(int) error { return st.sc.framer.writeData(st.id, end, data) }); err != nil {
+			return written, err
+		}
+
+		written += n
+		p = p[n:]
+		if len(p) == 0 {
+			return written, nil
+		}
+	}
+}
+
+// awaitWindow waits until the windows of the client allow sending some data,
+// and takes up to size bytes from them.
+func (st *http2Stream) awaitWindow(size int) (int, error) {
+	sc := st.sc
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	for {
+		if err := st.writable(); err != nil {
+			return 0, err
+		}
+
+		if size == 0 {
+			return 0, nil
+		}
+
+		n := min(int64(size), sc.sendWindow, st.sendWindow, int64(sc.maxFrameSize))
+		if n > 0 {
+			sc.sendWindow -= n
+			st.sendWindow -= n
+			return int(n), nil
+		}
+
+		sc.cond.Wait()
+	}
+}
+
+// writer sends the head of a response and returns a writer streaming its body, see Ctx.Writer.
+func (st *http2Stream) writer(response *Response, head bool) *http2Writer {
+	w := &http2Writer{
+		st:       st,
+		trailers: func() *Header { return response.trailers },
+		head:     head,
+	}
+	w.err = st.writeFields(response.statusCode, response.headers, head)
+
+	return w
+}
+
+// http2Writer streams a response body in DATA frames, each call to Write is sent right away.
+// The trailers are sent by Close in a last HEADERS frame.
+type http2Writer struct {
+	st       *http2Stream
+	trailers func() *Header
+	head     bool // only the head is sent for HEAD requests
+	err      error
+	closed   bool
+}
+
+func (w *http2Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errStreamEnded
+	}
+
+	if w.head || len(p) == 0 {
+		return len(p), nil
+	}
+
+	n, err := w.st.writeData(p, false)
+	w.err = err
+	return n, err
+}
+
+// Close ends the stream, with the trailers when there are some.
+func (w *http2Writer) Close() error {
+	if w.err != nil || w.closed {
+		return w.err
+	}
+	w.closed = true
+
+	if w.head {
+		return nil
+	}
+
+	if trailers := w.trailers(); trailers.Len() > 0 {
+		return w.st.writeFields(0, trailers, true)
+	}
+
+	_, err := w.st.writeData(nil, true)
+	return err
+}
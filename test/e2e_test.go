@@ -1,15 +1,19 @@
 package tests
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"log/slog"
-	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -24,8 +28,26 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func getRandomPort() int {
-	return 8000 + rand.Intn(1000)
+// listen serves the app on a random port until the end of the test and returns the port.
+func listen(t *testing.T, app *fast.App) int {
+	t.Helper()
+
+	go func() {
+		if err := app.Listen(":0"); err != nil {
+			t.Errorf("failed to start the server: %v", err)
+		}
+	}()
+	require.Eventually(t, func() bool {
+		return app.Addr() != nil
+	}, 3*time.Second, time.Millisecond)
+
+	t.Cleanup(func() {
+		if err := app.Shutdown(true); err != nil {
+			t.Errorf("failed to shutdown the server: %v", err)
+		}
+	})
+
+	return app.Addr().(*net.TCPAddr).Port
 }
 
 func TestMain(m *testing.M) {
@@ -35,6 +57,65 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+func TestListen(t *testing.T) {
+	t.Run("should return an error when the address is in use", func(t *testing.T) {
+		ln, err := net.Listen("tcp", ":0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		app := fast.New(fast.Config{})
+		assert.Nil(t, app.Addr())
+
+		err = app.Listen(ln.Addr().String())
+		assert.ErrorContains(t, err, "failed to listen on")
+	})
+
+	t.Run("should serve on an existing listener", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "fast.sock")
+		ln, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+
+		app := fast.New(fast.Config{})
+		app.Get("/", func(c *fast.Ctx) error {
+			return c.SendString("over a unix socket")
+		})
+
+		served := make(chan error)
+		go func() {
+			served <- app.Listener(ln)
+		}()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+
+		resp, err := client.Get("http://localhost/")
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, "over a unix socket", string(body))
+		assert.Equal(t, socket, app.Addr().String())
+
+		// Listener returns once the app is shut down
+		require.NoError(t, app.Shutdown(false))
+		assert.NoError(t, <-served)
+	})
+
+	t.Run("should not listen again once shut down", func(t *testing.T) {
+		app := fast.New(fast.Config{})
+		require.NoError(t, app.Shutdown(false))
+
+		assert.Error(t, app.Listen(":0"))
+	})
+}
+
 func TestHandler(t *testing.T) {
 	app := fast.New(
 		fast.Config{
@@ -62,20 +143,29 @@ func TestHandler(t *testing.T) {
 		return c.SendString("OK")
 	})
 
-	port := getRandomPort()
-	go func() {
-		err := app.Listen(fmt.Sprintf(":%d", port))
-		if err != nil {
-			log.Fatal("failed to start server for tests")
-		}
-	}()
+	app.Get("/users", func(c *fast.Ctx) error {
+		return c.JSON(fast.Map{
+			"id":   c.QueryInt("id"),
+			"tags": c.QueryValues("tag"),
+		})
+	})
 
-	t.Cleanup(func() {
-		if err := app.Shutdown(true); err != nil {
-			slog.Error("failed to shutdown the server")
-		}
+	app.Get("/users/:id/posts/:post?", func(c *fast.Ctx) error {
+		return c.JSON(c.AllParams())
+	})
+
+	app.Get("/static/*", func(c *fast.Ctx) error {
+		return c.SendString(c.Params("*"))
+	})
+
+	app.Get("/cookies", func(c *fast.Ctx) error {
+		c.Append("Set-Cookie", "session=abc")
+		c.Append("Set-Cookie", "theme=dark")
+		return c.SendString(strings.Join(c.Request.Headers().Values("X-Forwarded-For"), ","))
 	})
 
+	port := listen(t, app)
+
 	t.Run("should return 200 for configured handler", func(t *testing.T) {
 		t.Parallel()
 
@@ -119,6 +209,79 @@ func TestHandler(t *testing.T) {
 		assert.Equal(t, fast.StatusOK, resp.StatusCode)
 	})
 
+	t.Run("should match the route ignoring the query string", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/users?id=1&tag=a&tag=b%%20c", port))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.JSONEq(t, `{"id": 1, "tags": ["a", "b c"]}`, string(raw))
+	})
+
+	t.Run("should expose the route params", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		tests := map[string]string{
+			"/users/42/posts/7":   `{"id": "42", "post": "7"}`,
+			"/users/42/posts":     `{"id": "42"}`,
+			"/users/a%2Fb/posts":  `{"id": "a/b"}`,
+			"/static/css/a.css":   `css/a.css`,
+			"/static/css/../a.js": `a.js`,
+		}
+
+		for path, expected := range tests {
+			resp, err := client.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+			require.NoError(t, err)
+
+			raw, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, fast.StatusOK, resp.StatusCode, path)
+			if strings.HasPrefix(expected, "{") {
+				assert.JSONEq(t, expected, string(raw), path)
+			} else {
+				assert.Equal(t, expected, string(raw), path)
+			}
+		}
+	})
+
+	t.Run("should send and receive repeated headers", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/cookies", port), nil)
+		require.NoError(t, err)
+		req.Header.Add("X-Forwarded-For", "10.0.0.1")
+		req.Header.Add("X-Forwarded-For", "10.0.0.2")
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, "10.0.0.1,10.0.0.2", string(raw))
+		assert.Equal(t, []string{"session=abc", "theme=dark"}, resp.Header.Values("Set-Cookie"))
+	})
+
 	t.Run("should return 404 for unexisting path", func(t *testing.T) {
 		t.Parallel()
 
@@ -190,178 +353,1676 @@ func TestHandler(t *testing.T) {
 	})
 }
 
-func TestConnectionTimeout(t *testing.T) {
-	app := fast.New(
-		fast.Config{
-			IdleTimeout: 5 * time.Second,
-		},
-	)
+func TestMethods(t *testing.T) {
+	app := fast.New(fast.Config{})
 
-	app.Get("/", func(c *fast.Ctx) error {
-		return c.SendString("OK")
-	})
+	echoMethod := func(c *fast.Ctx) error {
+		return c.SendString(c.Method())
+	}
 
-	app.Get("/get-json", func(c *fast.Ctx) error {
-		return c.JSON(fast.Map{
-			"message": "Hello World",
+	app.Post("/post", echoMethod)
+	app.Put("/put", echoMethod)
+	app.Patch("/patch", echoMethod)
+	app.Delete("/delete", echoMethod)
+	app.All("/all", echoMethod)
+
+	app.Route("/items").
+		Get(func(c *fast.Ctx) error { return c.SendString("list") }).
+		Post(func(c *fast.Ctx) error { return c.SendString("create") })
+
+	app.Group("/api").Route("/orders/:id").
+		Put(func(c *fast.Ctx) error { return c.SendString("update " + c.Params("id")) })
+
+	port := listen(t, app)
+
+	tests := []struct {
+		method   string
+		path     string
+		status   int
+		expected string
+	}{
+		{"POST", "/post", fast.StatusOK, "POST"},
+		{"PUT", "/put", fast.StatusOK, "PUT"},
+		{"PATCH", "/patch", fast.StatusOK, "PATCH"},
+		{"DELETE", "/delete", fast.StatusOK, "DELETE"},
+		{"GET", "/post", fast.StatusMethodNotAllowed, ""},
+		{"GET", "/unknown", fast.StatusNotFound, ""},
+		{"GET", "/all", fast.StatusOK, "GET"},
+		{"DELETE", "/all", fast.StatusOK, "DELETE"},
+		{"TRACE", "/all", fast.StatusOK, "TRACE"},
+		{"GET", "/items", fast.StatusOK, "list"},
+		{"POST", "/items", fast.StatusOK, "create"},
+		{"PUT", "/api/orders/7", fast.StatusOK, "update 7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("should handle %s %s", tt.method, tt.path), func(t *testing.T) {
+			t.Parallel()
+
+			client := &http.Client{
+				Timeout: 3 * time.Second,
+			}
+
+			req, err := http.NewRequest(tt.method, fmt.Sprintf("http://localhost:%d%s", port, tt.path), nil)
+			require.NoError(t, err)
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			raw, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.Equal(t, tt.expected, string(raw))
 		})
-	})
+	}
+}
 
-	customMiddleware := func(c *fast.Ctx) error {
-		err := c.Next()
-		c.Set("x-middleware-header", "true")
-		return err
+func TestMethodNotAllowed(t *testing.T) {
+	newApp := func(config fast.Config) int {
+		app := fast.New(config)
+		app.Use(func(c *fast.Ctx) error {
+			c.Set("X-Middleware", "true")
+			return c.Next()
+		})
+
+		app.Route("/items/:id").
+			Get(func(c *fast.Ctx) error { return c.SendString("get") }).
+			Delete(func(c *fast.Ctx) error { return c.SendString("delete") })
+
+		app.Options("/custom", func(c *fast.Ctx) error { return c.SendString("custom options") })
+
+		port := listen(t, app)
+
+		return port
 	}
 
-	app.Get("/custom-middleware", customMiddleware, func(c *fast.Ctx) error {
-		return c.SendString("OK")
+	do := func(t *testing.T, method string, port int, path string) (*http.Response, string) {
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		req, err := http.NewRequest(method, fmt.Sprintf("http://localhost:%d%s", port, path), nil)
+		require.NoError(t, err)
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(raw)
+	}
+
+	port := newApp(fast.Config{})
+	disabledPort := newApp(fast.Config{
+		DisableMethodNotAllowed: true,
+		DisableAutoOptions:      true,
 	})
 
-	app.Get("/handle-connection-close", func(c *fast.Ctx) error {
-		c.Request.SetHeader("connection", "close") // manually setting the request header
-		return c.SendString("OK")
+	t.Run("should return 405 with the allowed methods", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := do(t, "POST", port, "/items/1")
+
+		assert.Equal(t, fast.StatusMethodNotAllowed, resp.StatusCode)
+		assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", resp.Header.Get("Allow"))
 	})
 
-	port := getRandomPort()
-	go func() {
-		slog.Info("TestConnectionTimeout - Listening on", "port", port)
+	t.Run("should answer OPTIONS automatically after the middlewares", func(t *testing.T) {
+		t.Parallel()
 
-		err := app.Listen(fmt.Sprintf(":%d", port))
-		if err != nil {
-			log.Fatal("failed to start server for tests")
-		}
-	}()
+		resp, _ := do(t, "OPTIONS", port, "/items/1")
 
-	t.Run("should handle the connection close header", func(t *testing.T) {
+		assert.Equal(t, fast.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "GET, HEAD, DELETE, OPTIONS", resp.Header.Get("Allow"))
+		assert.Equal(t, "true", resp.Header.Get("X-Middleware"))
+		// a 204 has no body, not even an announced empty one
+		assert.Empty(t, resp.Header.Values("Content-Length"))
+	})
+
+	t.Run("should keep the registered OPTIONS handler", func(t *testing.T) {
 		t.Parallel()
-		// five times to ensure a new connection is created each time.
-		for range 5 {
-			client := &http.Client{
-				Timeout: 3 * time.Second,
-			}
 
-			req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/handle-connection-close", port), nil)
-			trace := &httptrace.ClientTrace{
-				GotConn: func(gci httptrace.GotConnInfo) {
-					require.Equal(t, false, gci.Reused)
-				},
-			}
+		resp, body := do(t, "OPTIONS", port, "/custom")
 
-			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "custom options", body)
+	})
 
-			resp, err := client.Do(req)
-			require.NoError(t, err)
+	t.Run("should return 404 when both are disabled", func(t *testing.T) {
+		t.Parallel()
 
-			respBody, err := io.ReadAll(resp.Body)
-			require.NoError(t, err)
-			resp.Body.Close()
+		resp, _ := do(t, "POST", disabledPort, "/items/1")
+		assert.Equal(t, fast.StatusNotFound, resp.StatusCode)
 
-			assert.Equal(t, []byte("OK"), respBody)
-			assert.Equal(t, fast.StatusOK, resp.StatusCode)
-		}
+		resp, _ = do(t, "OPTIONS", disabledPort, "/items/1")
+		assert.Equal(t, fast.StatusNotFound, resp.StatusCode)
 	})
 }
 
-func TestMiddlewares(t *testing.T) {
-	app := fast.New(
-		fast.Config{
-			IdleTimeout: 5 * time.Second,
-		},
-	)
-
-	app.Use(cors.New())
-	app.Use(recovery.New())
-	app.Use(compress.New())
+func TestHead(t *testing.T) {
+	app := fast.New(fast.Config{})
 
-	app.Get("/middleware", func(c *fast.Ctx) error {
-		return c.SendString("OK")
+	app.Get("/resource", func(c *fast.Ctx) error {
+		c.Set("X-Handler", "get")
+		return c.SendString("resource body")
 	})
 
-	app.Get("/recovery", func(c *fast.Ctx) error {
-		panic("expected panic to be recovered")
+	app.Get("/stream", func(c *fast.Ctx) error {
+		return c.SendStream(strings.NewReader("streamed body"))
 	})
 
-	port := getRandomPort()
-	go func() {
-		err := app.Listen(fmt.Sprintf(":%d", port))
-		if err != nil {
-			log.Fatal("failed to start server for tests")
-		}
-	}()
+	app.Get("/explicit", func(c *fast.Ctx) error {
+		return c.SendString("get body")
+	})
 
-	t.Cleanup(func() {
-		if err := app.Shutdown(true); err != nil {
-			slog.Error("failed to shutdown the server")
-		}
+	app.Head("/explicit", func(c *fast.Ctx) error {
+		c.Set("X-Handler", "head")
+		return c.SendStatus(fast.StatusNoContent)
 	})
 
-	t.Run("should return 200 after handle middleware for CORS", func(t *testing.T) {
-		t.Parallel()
+	port := listen(t, app)
 
-		client := &http.Client{
-			Timeout: 3 * time.Second,
-		}
+	// a raw connection makes sure no body bytes are sent after the headers
+	head := func(t *testing.T, path string) (*http.Response, *bufio.Reader) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
 
-		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/middleware", port))
+		// the GET after the HEAD fails to parse if the previous response had a body
+		_, err = fmt.Fprintf(conn, "HEAD %s HTTP/1.1\r\nHost: localhost\r\n\r\nGET /resource HTTP/1.1\r\nHost: localhost\r\n\r\n", path)
 		require.NoError(t, err)
-		defer resp.Body.Close()
 
-		respBody, err := io.ReadAll(resp.Body)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, &http.Request{Method: "HEAD"})
 		require.NoError(t, err)
 
-		assert.Equal(t, respBody, []byte("OK"))
+		return resp, reader
+	}
 
-		assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
-		assert.Equal(t, "GET,POST,PUT,DELETE,OPTIONS", resp.Header.Get("Access-Control-Allow-Methods"))
-		assert.Equal(t, "Content-Type, Authorization", resp.Header.Get("Access-Control-Allow-Headers"))
+	t.Run("should answer HEAD with the GET headers and no body", func(t *testing.T) {
+		t.Parallel()
+
+		resp, reader := head(t, "/resource")
 
 		assert.Equal(t, fast.StatusOK, resp.StatusCode)
-	})
+		assert.Equal(t, "13", resp.Header.Get("Content-Length"))
+		assert.Equal(t, "get", resp.Header.Get("X-Handler"))
 
-	t.Run("should handle middleware for recovery of panics", func(t *testing.T) {
-		client := &http.Client{
-			Timeout: 3 * time.Second,
-		}
+		next, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		defer next.Body.Close()
 
-		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/recovery", port))
+		body, err := io.ReadAll(next.Body)
 		require.NoError(t, err)
-		defer resp.Body.Close()
+		assert.Equal(t, "resource body", string(body))
+	})
 
-		respBody, err := io.ReadAll(resp.Body)
+	t.Run("should leave out the chunks of a streamed response", func(t *testing.T) {
+		t.Parallel()
+
+		resp, reader := head(t, "/stream")
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+
+		next, err := http.ReadResponse(reader, nil)
 		require.NoError(t, err)
+		defer next.Body.Close()
 
-		assert.Equal(t, respBody, []byte("received an error while executing"))
-		assert.Equal(t, fast.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, fast.StatusOK, next.StatusCode)
 	})
-}
 
-func TestMiddleware_Compress(t *testing.T) {
-	app := fast.New(
-		fast.Config{},
-	)
+	t.Run("should prefer the registered HEAD route", func(t *testing.T) {
+		t.Parallel()
 
-	app.Use(compress.New())
+		resp, _ := head(t, "/explicit")
 
-	app.Get("/", func(c *fast.Ctx) error {
-		return c.SendString("OK")
+		assert.Equal(t, fast.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "head", resp.Header.Get("X-Handler"))
 	})
+}
+
+func TestGroup(t *testing.T) {
+	app := fast.New(fast.Config{})
+
+	auth := func(c *fast.Ctx) error {
+		if c.Get("Authorization") != "secret" {
+			return c.SendStatus(401)
+		}
+		return c.Next()
+	}
+
+	tag := func(header string) fast.Handler {
+		return func(c *fast.Ctx) error {
+			c.Set(header, "true")
+			return c.Next()
+		}
+	}
+
+	app.Get("/public", func(c *fast.Ctx) error {
+		return c.SendString("public")
+	})
+
+	api := app.Group("/api", tag("X-Api"))
+	v1 := api.Group("/v1", auth)
+	v1.Use(tag("X-V1"))
+
+	v1.Get("/users/:id", func(c *fast.Ctx) error {
+		return c.SendString("user " + c.Params("id"))
+	})
+
+	api.Get("/health", func(c *fast.Ctx) error {
+		return c.SendString("healthy")
+	})
+
+	port := listen(t, app)
+
+	get := func(t *testing.T, path string, headers map[string]string) (*http.Response, string) {
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d%s", port, path), nil)
+		require.NoError(t, err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		raw, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(raw)
+	}
+
+	t.Run("should run the middlewares of the nested groups", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := get(t, "/api/v1/users/42", map[string]string{"Authorization": "secret"})
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "user 42", body)
+		assert.Equal(t, "true", resp.Header.Get("X-Api"))
+		assert.Equal(t, "true", resp.Header.Get("X-V1"))
+	})
+
+	t.Run("should apply the group middleware only to its subtree", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := get(t, "/api/v1/users/42", nil)
+		assert.Equal(t, 401, resp.StatusCode)
+
+		resp, body := get(t, "/api/health", nil)
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "healthy", body)
+		assert.Equal(t, "true", resp.Header.Get("X-Api"))
+		assert.Empty(t, resp.Header.Get("X-V1"))
+
+		resp, body = get(t, "/public", nil)
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "public", body)
+		assert.Empty(t, resp.Header.Get("X-Api"))
+	})
+}
+
+func TestHeaderCase(t *testing.T) {
+	newApp := func(config fast.Config) int {
+		app := fast.New(config)
+		app.Get("/", func(c *fast.Ctx) error {
+			c.Set("x-LEGACY-header", "1")
+			return c.SendString("OK")
+		})
+
+		port := listen(t, app)
+
+		return port
+	}
+
+	// the raw response is read because the http client canonicalizes the keys
+	rawResponse := func(t *testing.T, port int) string {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+		require.NoError(t, err)
+
+		raw, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(raw)
+	}
+
+	t.Run("should send canonical keys in a stable order", func(t *testing.T) {
+		expected := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nX-Legacy-Header: 1\r\nConnection: close\r\n\r\nOK"
+		assert.Equal(t, expected, rawResponse(t, newApp(fast.Config{})))
+	})
+
+	t.Run("should send the keys as set by the handler when preserving the case", func(t *testing.T) {
+		expected := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nx-LEGACY-header: 1\r\nConnection: close\r\n\r\nOK"
+		assert.Equal(t, expected, rawResponse(t, newApp(fast.Config{PreserveHeaderCase: true})))
+	})
+}
+
+func TestRequestBody(t *testing.T) {
+	app := fast.New(fast.Config{})
+
+	app.Post("/echo", func(c *fast.Ctx) error {
+		c.Send(c.Body())
+		return nil
+	})
+
+	app.Post("/trailer", func(c *fast.Ctx) error {
+		body := c.Body() // the trailers come after the body
+		c.Set("X-Checksum", c.Request.GetTrailer("X-Checksum"))
+		c.Send(body)
+		return nil
+	})
+
+	port := listen(t, app)
+
+	t.Run("should read a body larger than a single read", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		body := strings.Repeat("{\"key\": \"value\"}\r\n", 10_000)
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/echo", port), "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, body, string(respBody))
+	})
+
+	t.Run("should keep the bytes after the body for the next request", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(
+			"POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nfirst" +
+				"POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 6\r\n\r\nsecond",
+		))
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		for _, expected := range []string{"first", "second"} {
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+
+			respBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, expected, string(respBody))
+		}
+	})
+	t.Run("should decode a chunked body and its trailers", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(
+			"POST /trailer HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Checksum: 42\r\n\r\n",
+		))
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello world", string(respBody))
+		assert.Equal(t, "42", resp.Header.Get("X-Checksum"))
+	})
+
+	t.Run("should return 431 for trailers over the header limits", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(
+			"POST /trailer HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n0\r\n" + strings.Repeat("X-Checksum: 42\r\n", 101) + "\r\n",
+		))
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, fast.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+	})
+
+	t.Run("should return 400 for a malformed chunked body", func(t *testing.T) {
+		t.Parallel()
+
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(
+			"POST /echo HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\nnot-hex\r\n",
+		))
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, fast.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestBodyLimit(t *testing.T) {
+	app := fast.New(fast.Config{
+		BodyLimit: 1024,
+	})
+
+	app.Post("/count", func(c *fast.Ctx) error {
+		n, err := io.Copy(io.Discard, c.BodyReader())
+		if err != nil {
+			return err
+		}
+		return c.SendString(fmt.Sprintf("%d", n))
+	})
+
+	app.Post("/ignore-body", func(c *fast.Ctx) error {
+		return c.SendString("ignored")
+	})
+
+	port := listen(t, app)
+
+	sendRaw := func(t *testing.T, raw string) (*bufio.Reader, net.Conn) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+
+		return bufio.NewReader(conn), conn
+	}
+
+	t.Run("should stream the body through the reader", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		resp, err := client.Post(fmt.Sprintf("http://localhost:%d/count", port), "text/plain", strings.NewReader(strings.Repeat("a", 1000)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "1000", string(respBody))
+	})
+
+	t.Run("should return 413 before reading a content length over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		reader, _ := sendRaw(t, "POST /count HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4096\r\n\r\n")
+
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, fast.StatusRequestEntityTooLarge, resp.StatusCode)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should return 413 when a chunked body goes over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		chunk := strings.Repeat("a", 512)
+		reader, _ := sendRaw(t, "POST /count HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+			strings.Repeat("200\r\n"+chunk+"\r\n", 3)+"0\r\n\r\n")
+
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, fast.StatusRequestEntityTooLarge, resp.StatusCode)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should drain an unread body and keep the connection alive", func(t *testing.T) {
+		t.Parallel()
+
+		reader, _ := sendRaw(t,
+			"POST /ignore-body HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789"+
+				"POST /count HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc",
+		)
+
+		for _, expected := range []string{"ignored", "3"} {
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+
+			respBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, fast.StatusOK, resp.StatusCode)
+			assert.Equal(t, expected, string(respBody))
+		}
+	})
+}
+
+func TestExpectContinue(t *testing.T) {
+	app := fast.New(fast.Config{
+		BodyLimit: 1024,
+	})
+
+	app.Post("/upload", func(c *fast.Ctx) error {
+		return c.SendString(fmt.Sprintf("received %d bytes", len(c.Body())))
+	})
+
+	app.Post("/protected", func(c *fast.Ctx) error {
+		if c.Get("Authorization") == "" {
+			return c.SendStatus(fast.StatusExpectationFailed)
+		}
+		return c.SendString(string(c.Body()))
+	})
+
+	port := listen(t, app)
+
+	dial := func(t *testing.T, head string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		_, err = conn.Write([]byte(head))
+		require.NoError(t, err)
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	readResponse := func(t *testing.T, reader *bufio.Reader) (*http.Response, string) {
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(body)
+	}
+
+	t.Run("should send 100 Continue when the handler reads the body", func(t *testing.T) {
+		t.Parallel()
+
+		conn, reader := dial(t, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+
+		// the body is only sent once the server asks for it
+		resp, _ := readResponse(t, reader)
+		assert.Equal(t, fast.StatusContinue, resp.StatusCode)
+
+		_, err := conn.Write([]byte("hello"))
+		require.NoError(t, err)
+
+		resp, body := readResponse(t, reader)
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "received 5 bytes", body)
+		assert.False(t, resp.Close)
+	})
+
+	t.Run("should reject a body over the limit before 100 Continue", func(t *testing.T) {
+		t.Parallel()
+
+		_, reader := dial(t, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4096\r\nExpect: 100-continue\r\n\r\n")
+
+		resp, _ := readResponse(t, reader)
+		assert.Equal(t, fast.StatusRequestEntityTooLarge, resp.StatusCode)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should let the handler reject the request before 100 Continue", func(t *testing.T) {
+		t.Parallel()
+
+		_, reader := dial(t, "POST /protected HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: 100-continue\r\n\r\n")
+
+		resp, _ := readResponse(t, reader)
+		assert.Equal(t, fast.StatusExpectationFailed, resp.StatusCode)
+		// the client may send the body or not, so the connection is closed
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should return 417 for an unknown expectation", func(t *testing.T) {
+		t.Parallel()
+
+		_, reader := dial(t, "POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: something-else\r\n\r\n")
+
+		resp, _ := readResponse(t, reader)
+		assert.Equal(t, fast.StatusExpectationFailed, resp.StatusCode)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should work with the net/http client", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+			Transport: &http.Transport{
+				// long enough for the test to fail if the server never answers
+				ExpectContinueTimeout: 2 * time.Second,
+			},
+		}
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://localhost:%d/upload", port), strings.NewReader(strings.Repeat("a", 512)))
+		require.NoError(t, err)
+		req.Header.Set("Expect", "100-continue")
+
+		start := time.Now()
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, "received 512 bytes", string(body))
+		assert.Less(t, time.Since(start), time.Second)
+	})
+}
+
+func TestRequestValidation(t *testing.T) {
+	app := fast.New(fast.Config{})
+
+	app.Post("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	port := listen(t, app)
+
+	tests := map[string]string{
+		"content length with transfer encoding": "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nGET / HTTP/1.1\r\n\r\n",
+		"conflicting content lengths":           "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Length: 10\r\n\r\nhello",
+		"obsolete line folding":                 "POST / HTTP/1.1\r\nHost: localhost\r\nX-Folded: a\r\n b\r\n\r\n",
+		"whitespace before colon":               "POST / HTTP/1.1\r\nHost : localhost\r\n\r\n",
+		"bare LF":                               "POST / HTTP/1.1\nHost: localhost\n\n",
+		"invalid method":                        "P@ST / HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		"unsupported protocol":                  "POST / HTTP/3.0\r\nHost: localhost\r\n\r\n",
+		"missing host":                          "POST / HTTP/1.1\r\n\r\n",
+		"target without a slash":                "POST admin HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	}
+
+	for name, raw := range tests {
+		t.Run("should return 400 and close the connection for "+name, func(t *testing.T) {
+			t.Parallel()
+
+			conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = conn.Write([]byte(raw))
+			require.NoError(t, err)
+
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			_, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, fast.StatusBadRequest, resp.StatusCode)
+			assert.True(t, resp.Close)
+
+			// nothing else is answered, the connection is closed
+			_, err = reader.ReadByte()
+			assert.ErrorIs(t, err, io.EOF)
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	app := fast.New(fast.Config{
+		MaxHeaderBytes: 1024,
+		MaxHeaderCount: 10,
+		MaxURILength:   64,
+	})
+
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	app.Get("/teapot", func(c *fast.Ctx) error {
+		return fast.NewError(418, "I'm a teapot")
+	})
+
+	app.Get("/internal", func(c *fast.Ctx) error {
+		return errors.New("connection to the database refused")
+	})
+
+	port := listen(t, app)
+
+	sendRaw := func(t *testing.T, raw string) (*http.Response, string) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp, string(body)
+	}
+
+	t.Run("should return 414 for a target over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := sendRaw(t, "GET /"+strings.Repeat("a", 100)+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		assert.Equal(t, fast.StatusRequestURITooLong, resp.StatusCode)
+		assert.Equal(t, "request target too long", body)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should return 431 for a head over the limit", func(t *testing.T) {
+		t.Parallel()
+
+		resp, _ := sendRaw(t, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Large: "+strings.Repeat("a", 2048)+"\r\n\r\n")
+
+		assert.Equal(t, fast.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+		assert.True(t, resp.Close)
+	})
+
+	t.Run("should return 431 for too many headers", func(t *testing.T) {
+		t.Parallel()
+
+		raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+		for i := range 10 {
+			raw += fmt.Sprintf("X-Header-%d: %d\r\n", i, i)
+		}
+
+		resp, body := sendRaw(t, raw+"\r\n")
+
+		assert.Equal(t, fast.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+		assert.Equal(t, "too many header fields", body)
+	})
+
+	t.Run("should answer a handler error with its code", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := sendRaw(t, "GET /teapot HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		assert.Equal(t, 418, resp.StatusCode)
+		assert.Equal(t, "I'm a teapot", body)
+		assert.False(t, resp.Close)
+	})
+
+	t.Run("should hide the message of other errors", func(t *testing.T) {
+		t.Parallel()
+
+		resp, body := sendRaw(t, "GET /internal HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		assert.Equal(t, fast.StatusInternalServerError, resp.StatusCode)
+		assert.Empty(t, body)
+	})
+}
+
+func TestCustomErrorHandler(t *testing.T) {
+	app := fast.New(fast.Config{
+		ErrorHandler: func(c *fast.Ctx, err error) error {
+			code := fast.StatusInternalServerError
+			var e *fast.Error
+			if errors.As(err, &e) {
+				code = e.Code
+			}
+
+			return c.Status(code).JSON(fast.Map{"error": err.Error()})
+		},
+	})
+
+	app.Get("/fail", func(c *fast.Ctx) error {
+		return errors.New("failed")
+	})
+
+	port := listen(t, app)
+
+	tests := []struct {
+		name     string
+		raw      string
+		status   int
+		expected string
+	}{
+		{
+			name:     "should answer the handler errors",
+			raw:      "GET /fail HTTP/1.1\r\nHost: localhost\r\n\r\n",
+			status:   fast.StatusInternalServerError,
+			expected: `{"error":"failed"}`,
+		},
+		{
+			name:     "should answer the malformed requests",
+			raw:      "GET /fail HTTP/1.1\r\nHost : localhost\r\n\r\n",
+			status:   fast.StatusBadRequest,
+			expected: `{"error":"whitespace between the header field name and the colon"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = conn.Write([]byte(tt.raw))
+			require.NoError(t, err)
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, resp.StatusCode)
+			assert.JSONEq(t, tt.expected, string(body))
+		})
+	}
+}
+
+func TestPipelining(t *testing.T) {
+	app := fast.New(fast.Config{})
+
+	app.Get("/slow", func(c *fast.Ctx) error {
+		time.Sleep(50 * time.Millisecond)
+		return c.SendString("slow")
+	})
+
+	app.Get("/fast", func(c *fast.Ctx) error {
+		return c.SendString("fast")
+	})
+
+	app.Post("/echo", func(c *fast.Ctx) error {
+		return c.SendString(string(c.Body()))
+	})
+
+	app.Get("/stream", func(c *fast.Ctx) error {
+		_, err := io.WriteString(c.Writer(), "streamed")
+		return err
+	})
+
+	port := listen(t, app)
+
+	t.Run("should answer the pipelined requests in order", func(t *testing.T) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		// every request is sent at once, before reading any response
+		_, err = conn.Write([]byte(
+			"GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+				"POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello" +
+				"GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+				"GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+				"POST /echo HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nbye\r\n0\r\n\r\n",
+		))
+		require.NoError(t, err)
+
+		reader := bufio.NewReader(conn)
+		for _, expected := range []string{"slow", "hello", "fast", "streamed", "bye"} {
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, fast.StatusOK, resp.StatusCode)
+			assert.Equal(t, expected, string(body))
+		}
+	})
+
+	t.Run("should answer a request split across several writes", func(t *testing.T) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET /fast HTTP/1.1\r\nHost: localhost\r\n\r\nGET /sl"))
+		require.NoError(t, err)
+
+		// the first response is sent without waiting for the rest of the second request
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "fast", string(body))
+
+		_, err = conn.Write([]byte("ow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		resp, err = http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "slow", string(body))
+	})
+}
+
+func TestConnectionPersistence(t *testing.T) {
+	app := fast.New(fast.Config{
+		IdleTimeout: 5 * time.Second,
+	})
+
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	app.Get("/stream", func(c *fast.Ctx) error {
+		c.SetTrailer("X-Checksum", "abc")
+		w := c.Writer()
+		for _, part := range []string{"one,", "two"} {
+			if _, err := io.WriteString(w, part); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	port := listen(t, app)
+
+	// send writes the requests at once and returns the responses and whether the server closed the connection
+	send := func(t *testing.T, raw string, count int) ([]*http.Response, []string, bool) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+
+		var responses []*http.Response
+		var bodies []string
+		reader := bufio.NewReader(conn)
+		for range count {
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			responses = append(responses, resp)
+			bodies = append(bodies, string(body))
+		}
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = reader.ReadByte()
+		return responses, bodies, errors.Is(err, io.EOF)
+	}
+
+	t.Run("should close HTTP/1.0 connections by default", func(t *testing.T) {
+		t.Parallel()
+
+		responses, bodies, closed := send(t, "GET / HTTP/1.0\r\n\r\n", 1)
+
+		assert.Equal(t, "HTTP/1.0", responses[0].Proto)
+		assert.Equal(t, "close", responses[0].Header.Get("Connection"))
+		assert.Equal(t, "OK", bodies[0])
+		assert.True(t, closed)
+	})
+
+	t.Run("should keep HTTP/1.0 connections alive when asked", func(t *testing.T) {
+		t.Parallel()
+
+		responses, _, closed := send(t, "GET / HTTP/1.0\r\nConnection: Keep-Alive\r\n\r\nGET / HTTP/1.0\r\n\r\n", 2)
+
+		assert.Equal(t, "keep-alive", responses[0].Header.Get("Connection"))
+		assert.Equal(t, "timeout=5", responses[0].Header.Get("Keep-Alive"))
+		assert.Equal(t, "close", responses[1].Header.Get("Connection"))
+		assert.Empty(t, responses[1].Header.Get("Keep-Alive"))
+		assert.True(t, closed)
+	})
+
+	t.Run("should keep HTTP/1.1 connections alive by default", func(t *testing.T) {
+		t.Parallel()
+
+		responses, _, closed := send(t, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", 1)
+
+		assert.Equal(t, "HTTP/1.1", responses[0].Proto)
+		assert.Equal(t, "keep-alive", responses[0].Header.Get("Connection"))
+		assert.Equal(t, "timeout=5", responses[0].Header.Get("Keep-Alive"))
+		assert.False(t, closed)
+	})
+
+	t.Run("should find close in the list of connection options", func(t *testing.T) {
+		t.Parallel()
+
+		responses, _, closed := send(t, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: TE, Close\r\n\r\n", 1)
+
+		assert.True(t, responses[0].Close)
+		assert.True(t, closed)
+	})
+
+	t.Run("should stream to HTTP/1.0 clients without chunks", func(t *testing.T) {
+		t.Parallel()
+
+		responses, bodies, closed := send(t, "GET /stream HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", 1)
+
+		assert.Empty(t, responses[0].TransferEncoding)
+		assert.Empty(t, responses[0].Header.Get("Trailer"))
+		assert.Equal(t, "close", responses[0].Header.Get("Connection"))
+		assert.Equal(t, "one,two", bodies[0])
+		assert.True(t, closed)
+	})
+}
+
+func TestStreamResponse(t *testing.T) {
+	app := fast.New(fast.Config{})
+
+	app.Get("/stream", func(c *fast.Ctx) error {
+		c.SetTrailer("X-Rows", "")
+
+		w := c.Writer()
+		for i := range 3 {
+			if _, err := fmt.Fprintf(w, "row %d\n", i); err != nil {
+				return err
+			}
+		}
+
+		c.SetTrailer("X-Rows", "3")
+		return nil
+	})
+
+	app.Get("/send-stream", func(c *fast.Ctx) error {
+		return c.SendStream(strings.NewReader(strings.Repeat("a", 100_000)))
+	})
+
+	port := listen(t, app)
+
+	t.Run("should stream the body with chunked encoding and trailers", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/stream", port))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+		assert.Equal(t, "row 0\nrow 1\nrow 2\n", string(respBody))
+		assert.Equal(t, "3", resp.Trailer.Get("X-Rows"))
+	})
+
+	t.Run("should keep the connection alive after a streamed response", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout:   3 * time.Second,
+			Transport: &http.Transport{},
+		}
+
+		for i := range 2 {
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/send-stream", port), nil)
+			trace := &httptrace.ClientTrace{
+				GotConn: func(gci httptrace.GotConnInfo) {
+					assert.Equal(t, i > 0, gci.Reused)
+				},
+			}
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+
+			respBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, fast.StatusOK, resp.StatusCode)
+			assert.Len(t, respBody, 100_000)
+		}
+	})
+}
+
+func TestConnectionTimeout(t *testing.T) {
+	app := fast.New(
+		fast.Config{
+			IdleTimeout: 5 * time.Second,
+		},
+	)
+
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	app.Get("/get-json", func(c *fast.Ctx) error {
+		return c.JSON(fast.Map{
+			"message": "Hello World",
+		})
+	})
+
+	customMiddleware := func(c *fast.Ctx) error {
+		err := c.Next()
+		c.Set("x-middleware-header", "true")
+		return err
+	}
+
+	app.Get("/custom-middleware", customMiddleware, func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	app.Get("/handle-connection-close", func(c *fast.Ctx) error {
+		c.Request.SetHeader("connection", "close") // manually setting the request header
+		return c.SendString("OK")
+	})
+
+	port := listen(t, app)
+
+	t.Run("should handle the connection close header", func(t *testing.T) {
+		t.Parallel()
+		// five times to ensure a new connection is created each time.
+		for range 5 {
+			client := &http.Client{
+				Timeout: 3 * time.Second,
+			}
 
-	port := getRandomPort()
-	go func() {
-		slog.Info("TestMiddleware_Compress - Listening on", "port", port)
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/handle-connection-close", port), nil)
+			trace := &httptrace.ClientTrace{
+				GotConn: func(gci httptrace.GotConnInfo) {
+					require.Equal(t, false, gci.Reused)
+				},
+			}
+
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+
+			respBody, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, []byte("OK"), respBody)
+			assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		}
+	})
+}
+
+func TestTimeouts(t *testing.T) {
+	app := fast.New(fast.Config{
+		IdleTimeout:       300 * time.Millisecond,
+		ReadHeaderTimeout: 200 * time.Millisecond,
+		ReadTimeout:       500 * time.Millisecond,
+	})
+
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	app.Post("/upload", func(c *fast.Ctx) error {
+		return c.SendString(string(c.Body()))
+	})
+
+	app.Get("/download", func(c *fast.Ctx) error {
+		w := c.Writer()
+		for range 5 {
+			if _, err := io.WriteString(w, "part,"); err != nil {
+				return err
+			}
+			time.Sleep(150 * time.Millisecond)
+		}
+		return nil
+	})
+
+	port := listen(t, app)
+
+	dial := func(t *testing.T) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	// waitClosed fails unless the server closes the connection within the timeout,
+	// it can be reset instead of closed when the client sent bytes that weren't read.
+	waitClosed := func(t *testing.T, conn net.Conn, reader *bufio.Reader, timeout time.Duration) {
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(timeout)))
+		_, err := reader.ReadByte()
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+	}
+
+	t.Run("should cut off a client trickling the headers", func(t *testing.T) {
+		t.Parallel()
+
+		conn, reader := dial(t)
+		_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+		require.NoError(t, err)
+
+		// the header timeout is shorter than the idle one, and each write doesn't extend it
+		for range 2 {
+			time.Sleep(100 * time.Millisecond)
+			_, _ = conn.Write([]byte("X-Slow: 1\r\n"))
+		}
+
+		waitClosed(t, conn, reader, time.Second)
+	})
+
+	t.Run("should close an idle keep-alive connection", func(t *testing.T) {
+		t.Parallel()
+
+		conn, reader := dial(t)
+		for range 2 {
+			_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			require.NoError(t, err)
+
+			resp, err := http.ReadResponse(reader, nil)
+			require.NoError(t, err)
+			_, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			// still within the idle timeout
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		start := time.Now()
+		waitClosed(t, conn, reader, time.Second)
+		assert.Greater(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("should cut off a body sent slower than the read timeout", func(t *testing.T) {
+		t.Parallel()
+
+		conn, reader := dial(t)
+		_, err := conn.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n"))
+		require.NoError(t, err)
+
+		for range 3 {
+			time.Sleep(200 * time.Millisecond)
+			_, _ = conn.Write([]byte("a"))
+		}
+
+		waitClosed(t, conn, reader, time.Second)
+	})
+
+	t.Run("should not cut off a download longer than the idle timeout", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout:   3 * time.Second,
+			Transport: &http.Transport{},
+		}
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/download", port))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("part,", 5), string(body))
+	})
+}
+
+func TestWriteTimeout(t *testing.T) {
+	app := fast.New(fast.Config{
+		WriteTimeout: 100 * time.Millisecond,
+	})
+
+	app.Get("/slow", func(c *fast.Ctx) error {
+		time.Sleep(300 * time.Millisecond)
+		return c.SendString("too late")
+	})
+
+	port := listen(t, app)
+
+	t.Run("should drop a response written after the write timeout", func(t *testing.T) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		// nothing is received before the connection is closed
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = bufio.NewReader(conn).ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestConnectionLimits(t *testing.T) {
+	start := func(t *testing.T, config fast.Config) int {
+		app := fast.New(config)
+		app.Get("/", func(c *fast.Ctx) error {
+			return c.SendString("OK")
+		})
+
+		port := listen(t, app)
+
+		return port
+	}
+
+	type client struct {
+		conn   net.Conn
+		reader *bufio.Reader
+	}
+
+	dial := func(t *testing.T, port int) *client {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
 
-		err := app.Listen(fmt.Sprintf(":%d", port))
+		return &client{conn: conn, reader: bufio.NewReader(conn)}
+	}
+
+	// request sends a request on the connection and returns its response,
+	// it doesn't fail the test so it can be used in require.Eventually.
+	request := func(c *client) (*http.Response, error) {
+		if _, err := c.conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+			return nil, err
+		}
+
+		resp, err := http.ReadResponse(c.reader, nil)
 		if err != nil {
-			log.Fatal("failed to start server for tests")
+			return nil, err
 		}
-	}()
+		defer resp.Body.Close()
 
-	t.Cleanup(func() {
-		if err := app.Shutdown(true); err != nil {
-			slog.Error("failed to shutdown the server")
+		_, err = io.ReadAll(resp.Body)
+		return resp, err
+	}
+
+	// served dials until a connection is accepted, a closed one may still be counted for a moment
+	served := func(t *testing.T, port int) *client {
+		var accepted *client
+		require.Eventually(t, func() bool {
+			c := dial(t, port)
+			resp, err := request(c)
+			if err != nil || resp.StatusCode != fast.StatusOK {
+				return false
+			}
+
+			accepted = c
+			return true
+		}, 3*time.Second, 10*time.Millisecond)
+
+		return accepted
+	}
+
+	t.Run("should reject the connections over the max", func(t *testing.T) {
+		port := start(t, fast.Config{MaxConns: 2})
+
+		first := served(t, port)
+		served(t, port)
+
+		resp, err := request(dial(t, port))
+		require.NoError(t, err)
+		assert.Equal(t, fast.StatusServiceUnavailable, resp.StatusCode)
+		assert.True(t, resp.Close)
+
+		// a slot is free again once a connection is closed
+		first.conn.Close()
+		served(t, port)
+	})
+
+	t.Run("should reject the connections over the max per IP", func(t *testing.T) {
+		port := start(t, fast.Config{MaxConnsPerIP: 1})
+
+		accepted := served(t, port)
+
+		resp, err := request(dial(t, port))
+		require.NoError(t, err)
+		assert.Equal(t, fast.StatusTooManyRequests, resp.StatusCode)
+		assert.True(t, resp.Close)
+
+		// the accepted connection keeps working
+		resp, err = request(accepted)
+		require.NoError(t, err)
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should close the connection after the max requests", func(t *testing.T) {
+		port := start(t, fast.Config{
+			IdleTimeout:        5 * time.Second,
+			MaxRequestsPerConn: 3,
+		})
+
+		c := dial(t, port)
+		for _, expected := range []string{"timeout=5, max=2", "timeout=5, max=1"} {
+			resp, err := request(c)
+			require.NoError(t, err)
+			assert.Equal(t, "keep-alive", resp.Header.Get("Connection"))
+			assert.Equal(t, expected, resp.Header.Get("Keep-Alive"))
+		}
+
+		resp, err := request(c)
+		require.NoError(t, err)
+		assert.True(t, resp.Close)
+		assert.Empty(t, resp.Header.Get("Keep-Alive"))
+
+		_, err = c.reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	// start serves "/" and a "/slow" route that signals when it starts and takes the delay
+	start := func(t *testing.T, delay time.Duration) (*fast.App, int, chan struct{}) {
+		app := fast.New(fast.Config{
+			IdleTimeout: 10 * time.Second,
+		})
+
+		started := make(chan struct{}, 1)
+		app.Get("/", func(c *fast.Ctx) error {
+			return c.SendString("OK")
+		})
+		app.Get("/slow", func(c *fast.Ctx) error {
+			started <- struct{}{}
+			time.Sleep(delay)
+			return c.SendString("done")
+		})
+
+		port := listen(t, app)
+
+		return app, port, started
+	}
+
+	send := func(t *testing.T, port int, path string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	t.Run("should let the requests being handled finish", func(t *testing.T) {
+		app, port, started := start(t, 300*time.Millisecond)
+
+		_, reader := send(t, port, "/slow")
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+
+		shutdown := make(chan error)
+		go func() {
+			shutdown <- app.ShutdownWithContext(ctx)
+		}()
+
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, "done", string(body))
+		assert.True(t, resp.Close)
+		assert.NoError(t, <-shutdown)
+
+		// the listener is closed
+		_, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		assert.Error(t, err)
+	})
+
+	t.Run("should close the idle keep-alive connections right away", func(t *testing.T) {
+		app, port, _ := start(t, 0)
+
+		conn, reader := send(t, port, "/")
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.False(t, resp.Close)
+
+		// the idle timeout is way longer than this
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, app.ShutdownWithContext(ctx))
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("should close the remaining connections at the deadline", func(t *testing.T) {
+		app, port, started := start(t, 3*time.Second)
+
+		conn, reader := send(t, port, "/slow")
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		begin := time.Now()
+		err := app.ShutdownWithContext(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, time.Since(begin), time.Second)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err = reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("should close every connection when forced", func(t *testing.T) {
+		app, port, started := start(t, 3*time.Second)
+
+		conn, reader := send(t, port, "/slow")
+		<-started
+
+		require.NoError(t, app.Shutdown(true))
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		_, err := reader.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	})
+}
+
+func TestMiddlewares(t *testing.T) {
+	app := fast.New(
+		fast.Config{
+			IdleTimeout: 5 * time.Second,
+		},
+	)
+
+	app.Use(cors.New())
+	app.Use(recovery.New())
+	app.Use(compress.New())
+
+	app.Get("/middleware", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	app.Get("/recovery", func(c *fast.Ctx) error {
+		panic("expected panic to be recovered")
+	})
+
+	port := listen(t, app)
+
+	t.Run("should return 200 after handle middleware for CORS", func(t *testing.T) {
+		t.Parallel()
+
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+		}
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/middleware", port))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, respBody, []byte("OK"))
+
+		assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET,POST,PUT,DELETE,OPTIONS", resp.Header.Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Content-Type, Authorization", resp.Header.Get("Access-Control-Allow-Headers"))
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("should handle middleware for recovery of panics", func(t *testing.T) {
+		client := &http.Client{
+			Timeout: 3 * time.Second,
 		}
+
+		resp, err := client.Get(fmt.Sprintf("http://localhost:%d/recovery", port))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, respBody, []byte("received an error while executing"))
+		assert.Equal(t, fast.StatusServiceUnavailable, resp.StatusCode)
+	})
+}
+
+func TestMiddleware_Compress(t *testing.T) {
+	app := fast.New(
+		fast.Config{},
+	)
+
+	app.Use(compress.New())
+
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
 	})
 
+	port := listen(t, app)
+
 	t.Run("should handle middleware with compression", func(t *testing.T) {
 		t.Parallel()
 
@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"fast"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// http2Server starts the app with HTTP/2 over TLS or with h2c, and returns its URL and a client speaking HTTP/2 only.
+func http2Server(t *testing.T, app *fast.App, secure bool) (string, *http.Client) {
+	t.Helper()
+
+	protocols := new(http.Protocols)
+	transport := &http.Transport{Protocols: protocols}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	if !secure {
+		protocols.SetUnencryptedHTTP2(true)
+		return fmt.Sprintf("http://localhost:%d", listen(t, app)), client
+	}
+
+	certFile, keyFile, cert := writeCertificate(t, t.TempDir(), "server", "localhost")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	protocols.SetHTTP2(true)
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	return fmt.Sprintf("https://localhost:%d", listenTLS(t, app, certFile, keyFile)), client
+}
+
+func TestHTTP2(t *testing.T) {
+	for _, secure := range []bool{true, false} {
+		name := "h2c with prior knowledge"
+		if secure {
+			name = "h2 over TLS"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			app := fast.New(fast.Config{BodyLimit: 8 << 20})
+			app.Use(func(c *fast.Ctx) error {
+				c.Set("X-Middleware", "ran")
+				return c.Next()
+			})
+			app.Get("/users/:id", func(c *fast.Ctx) error {
+				c.Set("Content-Type", "text/plain")
+				return c.SendString(fmt.Sprintf("user %s, %s, %s, %s", c.Params("id"), c.Query("tab"), c.Get("X-Custom"), c.Request.Protocol))
+			})
+			app.Post("/echo", func(c *fast.Ctx) error {
+				c.Send(c.Body())
+				return nil
+			})
+			app.Get("/stream", func(c *fast.Ctx) error {
+				c.SetTrailer("X-Checksum", "")
+				w := c.Writer()
+				for i := range 3 {
+					if _, err := fmt.Fprintf(w, "chunk %d\n", i); err != nil {
+						return err
+					}
+				}
+				c.SetTrailer("X-Checksum", "abc")
+				return nil
+			})
+			app.Get("/fail", func(c *fast.Ctx) error {
+				return fast.NewError(fast.StatusForbidden, "nope")
+			})
+
+			url, client := http2Server(t, app, secure)
+
+			t.Run("should dispatch to the routes and the middlewares", func(t *testing.T) {
+				req, err := http.NewRequest(http.MethodGet, url+"/users/42?tab=posts", nil)
+				require.NoError(t, err)
+				req.Header.Set("X-Custom", "value")
+
+				resp, err := client.Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				assert.Equal(t, 2, resp.ProtoMajor)
+				assert.Equal(t, fast.StatusOK, resp.StatusCode)
+				assert.Equal(t, "user 42, posts, value, HTTP/2.0", string(body))
+				assert.Equal(t, "ran", resp.Header.Get("X-Middleware"))
+				assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+				assert.Equal(t, int64(len(body)), resp.ContentLength)
+				assert.Empty(t, resp.Header.Get("Connection"))
+				assert.Empty(t, resp.Header.Get("Keep-Alive"))
+			})
+
+			t.Run("should send the head of HEAD requests without the body", func(t *testing.T) {
+				resp, err := client.Head(url + "/users/42")
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				assert.Equal(t, fast.StatusOK, resp.StatusCode)
+				assert.Equal(t, "21", resp.Header.Get("Content-Length"))
+				assert.Empty(t, body)
+			})
+
+			t.Run("should read and write bodies larger than the flow-control windows", func(t *testing.T) {
+				payload := bytes.Repeat([]byte("0123456789abcdef"), 5<<20/16)
+
+				resp, err := client.Post(url+"/echo", "application/octet-stream", bytes.NewReader(payload))
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				assert.Equal(t, fast.StatusOK, resp.StatusCode)
+				assert.True(t, bytes.Equal(payload, body), "the echoed body differs")
+			})
+
+			t.Run("should read the bodies of unknown length", func(t *testing.T) {
+				resp, err := client.Post(url+"/echo", "text/plain", io.MultiReader(strings.NewReader("hello "), strings.NewReader("world")))
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				assert.Equal(t, "hello world", string(body))
+			})
+
+			t.Run("should stream the response with its trailers", func(t *testing.T) {
+				resp, err := client.Get(url + "/stream")
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+
+				assert.Equal(t, "chunk 0\nchunk 1\nchunk 2\n", string(body))
+				assert.Equal(t, int64(-1), resp.ContentLength)
+				assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+			})
+
+			t.Run("should answer the errors as for HTTP/1", func(t *testing.T) {
+				tests := []struct {
+					method string
+					path   string
+					body   io.Reader
+					status int
+				}{
+					{http.MethodGet, "/missing", nil, fast.StatusNotFound},
+					{http.MethodDelete, "/users/42", nil, fast.StatusMethodNotAllowed},
+					{http.MethodGet, "/fail", nil, fast.StatusForbidden},
+					{http.MethodPost, "/echo", bytes.NewReader(make([]byte, 9<<20)), fast.StatusRequestEntityTooLarge},
+				}
+
+				for _, tt := range tests {
+					req, err := http.NewRequest(tt.method, url+tt.path, tt.body)
+					require.NoError(t, err)
+
+					resp, err := client.Do(req)
+					require.NoError(t, err)
+					resp.Body.Close()
+
+					assert.Equal(t, tt.status, resp.StatusCode, tt.path)
+				}
+			})
+		})
+	}
+}
+
+func TestHTTP2Multiplexing(t *testing.T) {
+	app := fast.New(fast.Config{})
+
+	// the first request only returns once the second one arrived on the same connection
+	release := make(chan struct{})
+	app.Get("/wait", func(c *fast.Ctx) error {
+		select {
+		case <-release:
+			return c.SendString("released")
+		case <-time.After(3 * time.Second):
+			return c.SendStatus(fast.StatusServiceUnavailable)
+		}
+	})
+	app.Get("/release", func(c *fast.Ctx) error {
+		close(release)
+		return c.SendString("done")
+	})
+
+	url, client := http2Server(t, app, false)
+
+	var wg sync.WaitGroup
+	var waitStatus int
+	var waitBody []byte
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		resp, err := client.Get(url + "/wait")
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer resp.Body.Close()
+
+		waitStatus = resp.StatusCode
+		waitBody, _ = io.ReadAll(resp.Body)
+	}()
+
+	// the client opens a single connection for both streams
+	time.Sleep(100 * time.Millisecond)
+	resp, err := client.Get(url + "/release")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	wg.Wait()
+	assert.Equal(t, fast.StatusOK, waitStatus)
+	assert.Equal(t, "released", string(waitBody))
+}
+
+func TestHTTP2Disabled(t *testing.T) {
+	app := fast.New(fast.Config{DisableHTTP2: true})
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString(c.Request.Protocol)
+	})
+
+	certFile, keyFile, cert := writeCertificate(t, t.TempDir(), "server", "localhost")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	port := listenTLS(t, app, certFile, keyFile)
+
+	// the client asks for h2 and gets HTTP/1.1
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: true}
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Get(fmt.Sprintf("https://localhost:%d/", port))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, resp.ProtoMajor)
+	assert.Equal(t, "HTTP/1.1", string(body))
+}
@@ -0,0 +1,509 @@
+package tests
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"fast"
+	"fast/middleware/mtls"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate for the names and its key in dir,
+// the certificate is also returned so the clients can trust it.
+func writeCertificate(t *testing.T, dir, prefix string, names ...string) (string, string, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, prefix+".crt")
+	keyFile := filepath.Join(dir, prefix+".key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	return certFile, keyFile, cert
+}
+
+// listenTLS serves the app over TLS on a random port until the end of the test and returns the port.
+func listenTLS(t *testing.T, app *fast.App, certFile, keyFile string) int {
+	t.Helper()
+
+	go func() {
+		if err := app.ListenTLS(":0", certFile, keyFile); err != nil {
+			t.Errorf("failed to start the server: %v", err)
+		}
+	}()
+	require.Eventually(t, func() bool {
+		return app.Addr() != nil
+	}, 3*time.Second, time.Millisecond)
+
+	t.Cleanup(func() {
+		if err := app.Shutdown(true); err != nil {
+			t.Errorf("failed to shutdown the server: %v", err)
+		}
+	})
+
+	return app.Addr().(*net.TCPAddr).Port
+}
+
+// peerCertificate returns the certificate sent by the server for the name.
+func peerCertificate(t *testing.T, port int, name string, roots *x509.CertPool) *x509.Certificate {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{
+		ServerName: name,
+		RootCAs:    roots,
+	})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0]
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, cert := writeCertificate(t, dir, "localhost", "localhost")
+
+	app := fast.New(fast.Config{})
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString(fmt.Sprintf("%s %t", c.Protocol(), c.Secure()))
+	})
+
+	port := listenTLS(t, app, certFile, keyFile)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	t.Run("should serve over TLS", func(t *testing.T) {
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		}
+
+		resp, err := client.Get(fmt.Sprintf("https://localhost:%d/", port))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, "https true", string(body))
+	})
+
+	t.Run("should report plain text connections", func(t *testing.T) {
+		plain := fast.New(fast.Config{})
+		plain.Get("/", func(c *fast.Ctx) error {
+			return c.SendString(fmt.Sprintf("%s %t", c.Protocol(), c.Secure()))
+		})
+		plainPort := listen(t, plain)
+
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/", plainPort))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "http false", string(body))
+	})
+
+	t.Run("should fail to start with a missing certificate", func(t *testing.T) {
+		other := fast.New(fast.Config{})
+		err := other.ListenTLS(":0", filepath.Join(dir, "missing.crt"), keyFile)
+		assert.Error(t, err)
+	})
+}
+
+func TestTLSCertificateSelection(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA, a := writeCertificate(t, dir, "a", "a.example.com")
+	certB, keyB, b := writeCertificate(t, dir, "b", "b.example.com", "*.b.example.com")
+
+	app := fast.New(fast.Config{})
+	app.Get("/", func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	})
+	require.NoError(t, app.AddCertificate(certB, keyB))
+
+	port := listenTLS(t, app, certA, keyA)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(a)
+	roots.AddCert(b)
+
+	tests := map[string]*x509.Certificate{
+		"a.example.com":     a,
+		"b.example.com":     b,
+		"api.b.example.com": b,
+	}
+
+	for name, expected := range tests {
+		t.Run("should send the certificate of "+name, func(t *testing.T) {
+			got := peerCertificate(t, port, name, roots)
+			assert.Equal(t, expected.SerialNumber, got.SerialNumber)
+		})
+	}
+
+	t.Run("should send the first certificate for an unknown name", func(t *testing.T) {
+		conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{
+			ServerName:         "unknown.example.com",
+			InsecureSkipVerify: true,
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, a.SerialNumber, conn.ConnectionState().PeerCertificates[0].SerialNumber)
+	})
+
+	t.Run("should keep the certificates when listening again fails", func(t *testing.T) {
+		certC, keyC, _ := writeCertificate(t, dir, "c", "c.example.com")
+		assert.Error(t, app.ListenTLS(":0", certC, keyC))
+
+		conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{
+			ServerName:         "unknown.example.com",
+			InsecureSkipVerify: true,
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, a.SerialNumber, conn.ConnectionState().PeerCertificates[0].SerialNumber)
+	})
+}
+
+func TestTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA, a := writeCertificate(t, dir, "a", "a.example.com")
+	certB, keyB, b := writeCertificate(t, dir, "b", "b.example.com")
+
+	handler := func(c *fast.Ctx) error {
+		return c.SendString("OK")
+	}
+
+	t.Run("should ask the GetCertificate of TLSConfig first", func(t *testing.T) {
+		pair, err := tls.LoadX509KeyPair(certB, keyB)
+		require.NoError(t, err)
+
+		app := fast.New(fast.Config{
+			TLSConfig: &tls.Config{
+				GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+					if hello.ServerName == "b.example.com" {
+						return &pair, nil
+					}
+					return nil, nil
+				},
+			},
+		})
+		app.Get("/", handler)
+		port := listenTLS(t, app, certA, keyA)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(a)
+		roots.AddCert(b)
+
+		assert.Equal(t, b.SerialNumber, peerCertificate(t, port, "b.example.com", roots).SerialNumber)
+		// the certificate of ListenTLS when the GetCertificate of TLSConfig has none
+		assert.Equal(t, a.SerialNumber, peerCertificate(t, port, "a.example.com", roots).SerialNumber)
+	})
+
+	t.Run("should not offer HTTP/2 of TLSConfig when it's disabled", func(t *testing.T) {
+		config := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+		app := fast.New(fast.Config{DisableHTTP2: true, TLSConfig: config})
+		app.Get("/", handler)
+		port := listenTLS(t, app, certA, keyA)
+
+		conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{
+			ServerName:         "a.example.com",
+			InsecureSkipVerify: true,
+			NextProtos:         []string{"h2", "http/1.1"},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, "http/1.1", conn.ConnectionState().NegotiatedProtocol)
+		assert.Equal(t, []string{"h2", "http/1.1"}, config.NextProtos)
+	})
+}
+
+func TestTLSCertificateReload(t *testing.T) {
+	// reload checks that a new handshake gets the new certificate while the connection
+	// opened before keeps working with the previous one
+	reload := func(t *testing.T, config fast.Config, trigger func()) {
+		dir := t.TempDir()
+		certFile, keyFile, previous := writeCertificate(t, dir, "server", "localhost")
+
+		app := fast.New(config)
+		app.Get("/", func(c *fast.Ctx) error {
+			return c.SendString("OK")
+		})
+		port := listenTLS(t, app, certFile, keyFile)
+
+		roots := x509.NewCertPool()
+		roots.AddCert(previous)
+
+		conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{RootCAs: roots})
+		require.NoError(t, err)
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+
+		_, _, next := writeCertificate(t, dir, "server", "localhost")
+		roots.AddCert(next)
+		trigger()
+
+		require.Eventually(t, func() bool {
+			conn, err := tls.Dial("tcp", fmt.Sprintf("localhost:%d", port), &tls.Config{RootCAs: roots})
+			if err != nil {
+				return false
+			}
+			defer conn.Close()
+
+			return conn.ConnectionState().PeerCertificates[0].SerialNumber.Cmp(next.SerialNumber) == 0
+		}, 3*time.Second, 20*time.Millisecond)
+
+		_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, fast.StatusOK, resp.StatusCode)
+		assert.Equal(t, previous.SerialNumber, conn.ConnectionState().PeerCertificates[0].SerialNumber)
+	}
+
+	t.Run("should reload the certificate when the files change", func(t *testing.T) {
+		reload(t, fast.Config{CertReloadInterval: 20 * time.Millisecond}, func() {})
+	})
+
+	t.Run("should reload the certificate on SIGHUP", func(t *testing.T) {
+		reload(t, fast.Config{CertReloadInterval: -1}, func() {
+			process, err := os.FindProcess(os.Getpid())
+			require.NoError(t, err)
+			require.NoError(t, process.Signal(syscall.SIGHUP))
+		})
+	})
+}
+
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM file of the certificate
+}
+
+// newCertificateAuthority creates a CA and writes its certificate in dir.
+func newCertificateAuthority(t *testing.T, dir, name string) *certificateAuthority {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	file := filepath.Join(dir, name+".crt")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return &certificateAuthority{cert: cert, key: key, file: file}
+}
+
+// issue returns a client certificate signed by the CA.
+func (ca *certificateAuthority) issue(t *testing.T, commonName string, uris ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, raw := range uris {
+		uri, err := url.Parse(raw)
+		require.NoError(t, err)
+		template.URIs = append(template.URIs, uri)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, serverCert := writeCertificate(t, dir, "server", "localhost")
+	ca := newCertificateAuthority(t, dir, "clients")
+	otherCA := newCertificateAuthority(t, dir, "others")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+
+	// start serves the common name of the client certificate, and the same behind the middleware
+	start := func(t *testing.T, config fast.Config) int {
+		app := fast.New(config)
+		handler := func(c *fast.Ctx) error {
+			cert := c.ClientCert()
+			if cert == nil {
+				return c.SendString("anonymous")
+			}
+			return c.SendString(cert.Subject.CommonName)
+		}
+
+		app.Get("/", handler)
+		app.Get("/billing", mtls.New(mtls.Config{
+			SANs: []string{"spiffe://example.com/billing/*"},
+		}), handler)
+		app.Get("/admin", mtls.New(mtls.Config{
+			Subjects: []string{"CN=admin-*,O=Example"},
+		}), handler)
+
+		go func() {
+			if err := app.ListenMutualTLS(":0", certFile, keyFile, ca.file); err != nil {
+				t.Errorf("failed to start the server: %v", err)
+			}
+		}()
+		require.Eventually(t, func() bool {
+			return app.Addr() != nil
+		}, 3*time.Second, time.Millisecond)
+
+		t.Cleanup(func() {
+			if err := app.Shutdown(true); err != nil {
+				t.Errorf("failed to shutdown the server: %v", err)
+			}
+		})
+
+		return app.Addr().(*net.TCPAddr).Port
+	}
+
+	get := func(port int, path string, certs ...tls.Certificate) (int, string, error) {
+		client := &http.Client{
+			Timeout: 3 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+			},
+		}
+
+		resp, err := client.Get(fmt.Sprintf("https://localhost:%d%s", port, path))
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body), err
+	}
+
+	t.Run("when the client certificates are required", func(t *testing.T) {
+		port := start(t, fast.Config{})
+
+		t.Run("should expose the verified certificate", func(t *testing.T) {
+			status, body, err := get(port, "/", ca.issue(t, "billing"))
+			require.NoError(t, err)
+
+			assert.Equal(t, fast.StatusOK, status)
+			assert.Equal(t, "billing", body)
+		})
+
+		t.Run("should reject the clients without a certificate", func(t *testing.T) {
+			_, _, err := get(port, "/")
+			assert.Error(t, err)
+		})
+
+		t.Run("should reject the certificates of another authority", func(t *testing.T) {
+			_, _, err := get(port, "/", otherCA.issue(t, "billing"))
+			assert.Error(t, err)
+		})
+	})
+
+	t.Run("when the client certificates are verified if given", func(t *testing.T) {
+		port := start(t, fast.Config{
+			TLSConfig: &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven},
+		})
+
+		tests := []struct {
+			name     string
+			path     string
+			certs    []tls.Certificate
+			status   int
+			expected string
+		}{
+			{"should accept the clients without a certificate", "/", nil, fast.StatusOK, "anonymous"},
+			{"should expose the certificate when given", "/", []tls.Certificate{ca.issue(t, "orders")}, fast.StatusOK, "orders"},
+			{"should allow a matching SAN", "/billing", []tls.Certificate{ca.issue(t, "billing", "spiffe://example.com/billing/api")}, fast.StatusOK, "billing"},
+			{"should forbid another SAN", "/billing", []tls.Certificate{ca.issue(t, "orders", "spiffe://example.com/orders/api")}, fast.StatusForbidden, "client certificate not allowed"},
+			{"should allow a matching subject", "/admin", []tls.Certificate{ca.issue(t, "admin-alice")}, fast.StatusOK, "admin-alice"},
+			{"should forbid another subject", "/admin", []tls.Certificate{ca.issue(t, "billing")}, fast.StatusForbidden, "client certificate not allowed"},
+			{"should forbid the clients without a certificate", "/admin", nil, fast.StatusForbidden, "client certificate required"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				status, body, err := get(port, tt.path, tt.certs...)
+				require.NoError(t, err)
+
+				assert.Equal(t, tt.status, status)
+				assert.Equal(t, tt.expected, body)
+			})
+		}
+	})
+}
@@ -0,0 +1,303 @@
+package fast
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultCertReloadInterval = 10 * time.Second
+
+// ListenTLS serves the app over TLS on the address, see App.Listen. Config.TLSConfig is used
+// as the base configuration and the certificate is chosen with SNI among this one, the ones of
+// App.AddCertificate and the ones of Config.TLSConfig, once its GetCertificate returned none.
+// The files are reloaded on SIGHUP and when they change, the connections already open keep
+// the certificate of their handshake. HTTP/2 is offered with ALPN unless Config.DisableHTTP2 is set.
+func (app *App) ListenTLS(addr, certFile, keyFile string) error {
+	return app.listenTLS(addr, certFile, keyFile, app.tlsConfig())
+}
+
+func (app *App) listenTLS(addr, certFile, keyFile string, config *tls.Config) error {
+	if err := app.checkListening(); err != nil {
+		return err
+	}
+
+	cert, modTime, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	// the certificates of Config.TLSConfig are moved to the ones selected with SNI,
+	// since GetCertificate isn't called when they are set
+	static := config.Certificates
+	config.Certificates = nil
+	config.GetCertificate = app.getCertificate(config.GetCertificate)
+
+	// registered before serving, so a SIGHUP doesn't kill the process once the app listens
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	done := make(chan struct{})
+	defer close(done)
+
+	return app.serve(tls.NewListener(ln, config), func() {
+		app.certs.setDefault(certFiles{cert: certFile, key: keyFile, modTime: modTime}, cert, static)
+		// started after, so a reload doesn't put back the files without this certificate
+		go app.watchCertificates(reload, done)
+	})
+}
+
+// ListenMutualTLS serves the app over TLS as ListenTLS, also verifying the certificates of the
+// clients against the certificate authorities of the PEM file. The clients must send a certificate
+// unless Config.TLSConfig asks for another verification, as tls.VerifyClientCertIfGiven.
+// The verified certificate of a request is returned by Ctx.ClientCert.
+func (app *App) ListenMutualTLS(addr, certFile, keyFile, clientCAFile string) error {
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the client certificate authorities: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificate found in %s", clientCAFile)
+	}
+
+	config := app.tlsConfig()
+	config.ClientCAs = pool
+	if config.ClientAuth == tls.NoClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return app.listenTLS(addr, certFile, keyFile, config)
+}
+
+// AddCertificate adds a certificate chosen by ListenTLS when the client asks for one of its names.
+// The first certificate is used for the clients that don't send any name.
+func (app *App) AddCertificate(certFile, keyFile string) error {
+	return app.certs.add(certFile, keyFile)
+}
+
+// tlsConfig returns a copy of Config.TLSConfig for the listener, "h2" is removed
+// from its protocols when Config.DisableHTTP2 is set.
+func (app *App) tlsConfig() *tls.Config {
+	config := &tls.Config{}
+	if app.config.TLSConfig != nil {
+		config = app.config.TLSConfig.Clone()
+	}
+
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if app.config.DisableHTTP2 {
+		// cloned first, Clone shares the slice with Config.TLSConfig
+		config.NextProtos = slices.DeleteFunc(slices.Clone(config.NextProtos), func(proto string) bool {
+			return proto == "h2"
+		})
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+		if !app.config.DisableHTTP2 {
+			config.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+
+	return config
+}
+
+// getCertificate asks the GetCertificate of Config.TLSConfig first, as autocert, and uses
+// the certificates of the app when it has none for the client, as crypto/tls does with Certificates.
+func (app *App) getCertificate(base func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if base == nil {
+		return app.certs.get
+	}
+
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := base(hello)
+		if cert != nil || err != nil {
+			return cert, err
+		}
+		return app.certs.get(hello)
+	}
+}
+
+// watchCertificates reloads the certificate files on SIGHUP and when they change, until done is closed.
+func (app *App) watchCertificates(reload chan os.Signal, done chan struct{}) {
+	interval := app.config.CertReloadInterval
+	if interval == 0 {
+		interval = defaultCertReloadInterval
+	}
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-reload:
+			slog.Info("received SIGHUP, reloading the certificates")
+		case <-tick:
+			if !app.certs.changed() {
+				continue
+			}
+			slog.Info("the certificate files changed, reloading them")
+		}
+
+		if err := app.certs.reload(); err != nil {
+			slog.Error("failed to reload the certificates, keeping the previous ones", "error", err)
+		}
+	}
+}
+
+type certFiles struct {
+	cert, key string
+	modTime   time.Time // latest modification of the two files when they were loaded
+}
+
+// certificates are the certificates loaded from files, replaced as a whole on each reload.
+type certificates struct {
+	mu     sync.RWMutex
+	files  []certFiles
+	loaded []*tls.Certificate
+	static []tls.Certificate // the ones of Config.TLSConfig, after the files
+}
+
+func (c *certificates) add(certFile, keyFile string) error {
+	cert, modTime, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files = append(c.files, certFiles{cert: certFile, key: keyFile, modTime: modTime})
+	c.loaded = append(c.loaded, cert)
+	return nil
+}
+
+// setDefault installs the certificate of ListenTLS before the ones of AddCertificate,
+// and the ones of Config.TLSConfig after them.
+func (c *certificates) setDefault(files certFiles, cert *tls.Certificate, static []tls.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files = append([]certFiles{files}, c.files...)
+	c.loaded = append([]*tls.Certificate{cert}, c.loaded...)
+	c.static = static
+}
+
+// reload loads every file again, the previous certificates are kept when one of them fails.
+func (c *certificates) reload() error {
+	c.mu.RLock()
+	files := append([]certFiles(nil), c.files...)
+	c.mu.RUnlock()
+
+	loaded := make([]*tls.Certificate, len(files))
+	for i := range files {
+		cert, modTime, err := loadCertificate(files[i].cert, files[i].key)
+		if err != nil {
+			return err
+		}
+		loaded[i] = cert
+		files[i].modTime = modTime
+	}
+
+	c.mu.Lock()
+	c.files = files
+	c.loaded = loaded
+	c.mu.Unlock()
+	return nil
+}
+
+// changed reports whether a file was modified since it was loaded.
+func (c *certificates) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, files := range c.files {
+		modTime, err := latestModTime(files.cert, files.key)
+		if err == nil && !modTime.Equal(files.modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// get returns the first certificate valid for the name asked by the client (SNI), or the first one.
+func (c *certificates) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var first *tls.Certificate
+	for _, cert := range c.loaded {
+		if first == nil {
+			first = cert
+		}
+		if hello.ServerName != "" && hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	for i := range c.static {
+		if first == nil {
+			first = &c.static[i]
+		}
+		if hello.ServerName != "" && hello.SupportsCertificate(&c.static[i]) == nil {
+			return &c.static[i], nil
+		}
+	}
+
+	if first == nil {
+		return nil, errors.New("no certificate configured")
+	}
+	return first, nil
+}
+
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, time.Time, error) {
+	// read before loading, so a change in between is caught by the next check
+	modTime, err := latestModTime(certFile, keyFile)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to load the certificate %s: %w", certFile, err)
+	}
+
+	return &cert, modTime, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}